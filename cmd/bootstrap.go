@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/bootstrap"
+)
+
+var bootstrapDiff bool
+var projectID string
+var bootstrapProviders []string
+
+// bootstrapResult is the output of the bootstrap command
+type bootstrapResult struct {
+	XRDVersion string   `json:"xrdVersion"`
+	Installed  []string `json:"installed,omitempty"`
+	Diff       string   `json:"diff,omitempty"`
+}
+
+// bootstrapCmd installs the Crossplane resources into the ops cluster
+var bootstrapCmd = &cobra.Command{
+	Use:   "bootstrap",
+	Short: "install the crossplane resources into the ops cluster",
+	Long:  `Install or upgrade the providers, functions, XRD, compositions and ProviderConfigs embedded in this binary in the ops cluster. The Crossplane provider, composition and ProviderConfig of each cloud in --provider are installed. Running it again only applies the changes.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		opts := bootstrap.Options{ProjectID: projectID, Providers: bootstrapProviders}
+		result := bootstrapResult{XRDVersion: bootstrap.XRDVersion}
+		if bootstrapDiff {
+			diff, err := bootstrap.Diff(opts)
+			if err != nil {
+				log.Fatalf("Error diffing the ops cluster: %v", err)
+			}
+			result.Diff = diff
+			printResult(format, result, func(w io.Writer) {
+				if diff == "" {
+					fmt.Fprintln(w, "The ops cluster is up to date.")
+					return
+				}
+				fmt.Fprint(w, diff)
+			})
+			return
+		}
+		installed, err := bootstrap.Install(opts)
+		if err != nil {
+			log.Fatalf("Error bootstrapping the ops cluster: %v", err)
+		}
+		result.Installed = installed
+		printResult(format, result, func(w io.Writer) {
+			for _, step := range result.Installed {
+				fmt.Fprintf(w, "Installed %s\n", step)
+			}
+			fmt.Fprintf(w, "The ops cluster runs XRD version %s.\n", result.XRDVersion)
+		})
+	},
+}
+
+func init() {
+	bootstrapCmd.Flags().BoolVar(&bootstrapDiff, "diff", false, "Show the changes instead of applying them")
+	bootstrapCmd.Flags().StringSliceVar(&bootstrapProviders, "provider", bootstrap.DefaultProviders, "Clouds to install the Crossplane provider, composition and ProviderConfig of (gcp|aws|azure)")
+	bootstrapCmd.Flags().StringVar(&projectID, "gcp-project", "", "GCP project ID of the ProviderConfig, defaults to GCP_PROJECT or silogen-sandbox")
+}
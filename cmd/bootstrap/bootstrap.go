@@ -0,0 +1,277 @@
+package bootstrap
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/pkg"
+)
+
+//go:embed manifests/*
+var manifests embed.FS
+
+const (
+	// XRDName is the name of the NodeGroup XRD
+	XRDName = "nodegroups.tanuu.dev"
+	// XRDVersion is recorded on the XRD when it is installed. Bump it when the
+	// XRD changes in a way the claims rendered by create depend on.
+	XRDVersion = "2"
+	// VersionAnnotation is the annotation of the XRD that holds its version
+	VersionAnnotation = "tanuu.dev/xrd-version"
+)
+
+// cloud is what bootstrap installs for a provider of node groups
+type cloud struct {
+	// providers are the Crossplane provider packages in manifests/providers-<cloud>.yaml
+	providers []string
+	// composition is the composition in pkg/
+	composition string
+}
+
+// clouds are the providers bootstrap can install, the ProviderConfig of each
+// is manifests/providerconfig-<cloud>.yaml
+var clouds = map[string]cloud{
+	"gcp":   {providers: []string{"provider-gcp-compute"}, composition: "nodegroupcomp.yaml"},
+	"aws":   {providers: []string{"provider-aws-ec2"}, composition: "nodegroupcomp-aws.yaml"},
+	"azure": {providers: []string{"provider-azure-compute", "provider-azure-network"}, composition: "nodegroupcomp-azure.yaml"},
+}
+
+// DefaultProviders are the providers installed when none are chosen
+var DefaultProviders = []string{"gcp"}
+
+// waitTimeout is how long to wait for each installed resource
+const waitTimeout = 5 * time.Minute
+
+// Options parameterise the installed manifests
+type Options struct {
+	// ProjectID is the GCP project of the ProviderConfig, defaults to GCP_PROJECT
+	ProjectID string
+	// Providers are the clouds whose Crossplane providers, compositions and
+	// ProviderConfigs are installed, defaults to DefaultProviders
+	Providers []string
+}
+
+// Condition is a condition a resource must reach
+type Condition struct {
+	Resource  string
+	Condition string
+}
+
+// Step is a manifest that is applied, and the conditions to wait for before the next step
+type Step struct {
+	Name     string
+	Manifest []byte
+	WaitFor  []Condition
+}
+
+// Steps renders the manifests in the order they are installed
+func Steps(opts Options) ([]Step, error) {
+	if len(opts.Providers) == 0 {
+		opts.Providers = DefaultProviders
+	}
+	providers, err := manifests.ReadFile("manifests/provider-kubernetes-incluster.yaml")
+	if err != nil {
+		return nil, err
+	}
+	packages := [][]byte{providers}
+	waits := []Condition{}
+	comps := [][]byte{}
+	for _, name := range opts.Providers {
+		c, ok := clouds[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown provider %q, must be one of %s", name, strings.Join(names(), ", "))
+		}
+		manifest, err := manifests.ReadFile("manifests/providers-" + name + ".yaml")
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, manifest)
+		for _, provider := range c.providers {
+			waits = append(waits, Condition{"providers.pkg.crossplane.io/" + provider, "Healthy"})
+		}
+		comp, err := pkg.FS.ReadFile(c.composition)
+		if err != nil {
+			return nil, err
+		}
+		comps = append(comps, comp)
+	}
+	waits = append(waits,
+		Condition{"functions.pkg.crossplane.io/function-go-templating", "Healthy"},
+		Condition{"functions.pkg.crossplane.io/function-auto-ready", "Healthy"},
+	)
+	xrd, err := versionedXRD()
+	if err != nil {
+		return nil, err
+	}
+	configs, err := providerConfigs(opts)
+	if err != nil {
+		return nil, err
+	}
+	// crossplane.yaml is the package metadata, it is not applied
+	return []Step{
+		{
+			Name:     "providers and functions",
+			Manifest: bytes.Join(packages, []byte("---\n")),
+			WaitFor:  waits,
+		},
+		{
+			Name:     "XRD " + XRDName,
+			Manifest: xrd,
+			WaitFor:  []Condition{{"compositeresourcedefinitions/" + XRDName, "Established"}},
+		},
+		{
+			Name:     "compositions",
+			Manifest: bytes.Join(comps, []byte("---\n")),
+		},
+		{
+			Name:     "provider config",
+			Manifest: configs,
+		},
+	}, nil
+}
+
+// names returns the providers bootstrap can install
+func names() []string {
+	names := []string{}
+	for name := range clouds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// versionedXRD returns the XRD with its version annotation
+func versionedXRD() ([]byte, error) {
+	data, err := pkg.FS.ReadFile("nodegroupdef.yaml")
+	if err != nil {
+		return nil, err
+	}
+	xrd := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &xrd); err != nil {
+		return nil, fmt.Errorf("parsing the XRD: %w", err)
+	}
+	metadata, _ := xrd["metadata"].(map[string]interface{})
+	if metadata == nil {
+		return nil, fmt.Errorf("the XRD has no metadata")
+	}
+	annotations, _ := metadata["annotations"].(map[string]interface{})
+	if annotations == nil {
+		annotations = map[string]interface{}{}
+	}
+	annotations[VersionAnnotation] = XRDVersion
+	metadata["annotations"] = annotations
+	return yaml.Marshal(xrd)
+}
+
+// providerConfigs renders the ProviderConfigs of the providers
+func providerConfigs(opts Options) ([]byte, error) {
+	if opts.ProjectID == "" {
+		opts.ProjectID = os.Getenv("GCP_PROJECT")
+	}
+	if opts.ProjectID == "" {
+		opts.ProjectID = "silogen-sandbox"
+	}
+	if len(opts.Providers) == 0 {
+		opts.Providers = DefaultProviders
+	}
+	configs := [][]byte{}
+	for _, name := range opts.Providers {
+		tmpl, err := template.ParseFS(manifests, "manifests/providerconfig-"+name+".yaml")
+		if err != nil {
+			return nil, err
+		}
+		var out bytes.Buffer
+		if err := tmpl.Execute(&out, opts); err != nil {
+			return nil, err
+		}
+		configs = append(configs, out.Bytes())
+	}
+	return bytes.Join(configs, []byte("---\n")), nil
+}
+
+// Install installs or upgrades the manifests in the ops cluster.
+// Applying them again is a no-op, so it can run any number of times.
+func Install(opts Options) ([]string, error) {
+	steps, err := Steps(opts)
+	if err != nil {
+		return nil, err
+	}
+	installed := []string{}
+	for _, step := range steps {
+		log.Info("Installing ", step.Name)
+		if err := utils.ApplyManifest(step.Manifest); err != nil {
+			return installed, fmt.Errorf("installing %s: %w", step.Name, err)
+		}
+		for _, wait := range step.WaitFor {
+			if err := waitFor(wait.Resource, wait.Condition); err != nil {
+				return installed, err
+			}
+		}
+		installed = append(installed, step.Name)
+	}
+	return installed, nil
+}
+
+// waitFor waits until a resource reaches the condition. The resource may not
+// exist yet while Crossplane installs the package that defines it.
+func waitFor(resource, condition string) error {
+	deadline := utils.Now().Add(waitTimeout)
+	for {
+		err := utils.WaitForCondition(resource, condition, time.Minute)
+		if err == nil {
+			return nil
+		}
+		if !utils.Now().Before(deadline) {
+			return err
+		}
+		log.Debugf("Waiting for %s to be %s", resource, condition)
+		utils.Sleep(10 * time.Second)
+	}
+}
+
+// Diff returns the changes Install would make to the ops cluster
+func Diff(opts Options) (string, error) {
+	steps, err := Steps(opts)
+	if err != nil {
+		return "", err
+	}
+	diffs := []string{}
+	for _, step := range steps {
+		diff, err := utils.DiffManifest(step.Manifest)
+		if err != nil {
+			return strings.Join(diffs, ""), fmt.Errorf("diffing %s: %w", step.Name, err)
+		}
+		diffs = append(diffs, diff)
+	}
+	return strings.Join(diffs, ""), nil
+}
+
+// CheckInstalled checks that the ops cluster runs the XRD version this binary renders claims for
+func CheckInstalled() error {
+	xrd := struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}{}
+	if err := utils.GetResource("compositeresourcedefinitions", XRDName, &xrd); err != nil {
+		return fmt.Errorf("XRD %s is not installed, run `bootstrap`: %w", XRDName, err)
+	}
+	installed := xrd.Metadata.Annotations[VersionAnnotation]
+	if installed != XRDVersion {
+		if installed == "" {
+			installed = "unknown"
+		}
+		return fmt.Errorf("XRD %s has version %s, this binary needs version %s, run `bootstrap` to upgrade it", XRDName, installed, XRDVersion)
+	}
+	return nil
+}
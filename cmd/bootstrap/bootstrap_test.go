@@ -0,0 +1,317 @@
+package bootstrap
+
+import (
+	"bytes"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+	"github.com/tanuudev/tanuu-omni-nodes/pkg"
+)
+
+func TestSteps(t *testing.T) {
+	steps, err := Steps(Options{ProjectID: "my-project"})
+	if err != nil {
+		t.Fatalf("Steps: %v", err)
+	}
+	manifests := map[string]string{}
+	for _, step := range steps {
+		manifests[step.Name] = string(step.Manifest)
+	}
+
+	xrd := struct {
+		Metadata struct {
+			Name        string            `json:"name"`
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}{}
+	if err := yaml.Unmarshal([]byte(manifests["XRD "+XRDName]), &xrd); err != nil {
+		t.Fatalf("parsing the XRD: %v", err)
+	}
+	if xrd.Metadata.Name != XRDName || xrd.Metadata.Annotations[VersionAnnotation] != XRDVersion {
+		t.Errorf("XRD %s has annotations %v, want %s=%s", xrd.Metadata.Name, xrd.Metadata.Annotations, VersionAnnotation, XRDVersion)
+	}
+	if !strings.Contains(manifests["compositions"], "name: tanuunodegroup\n") {
+		t.Errorf("composition tanuunodegroup is not installed")
+	}
+	for _, name := range []string{"tanuunodegroup-aws", "tanuunodegroup-azure", "provider-aws-ec2"} {
+		if strings.Contains(manifests["compositions"]+manifests["providers and functions"], "name: "+name+"\n") {
+			t.Errorf("%s is installed without its provider", name)
+		}
+	}
+	if !strings.Contains(manifests["provider config"], `projectID: "my-project"`) {
+		t.Errorf("provider config does not use the project:\n%s", manifests["provider config"])
+	}
+	for name, manifest := range manifests {
+		// the Configuration package would install a second copy of the XRD
+		if strings.Contains(manifest, "kind: Configuration") {
+			t.Errorf("%s installs a Configuration package", name)
+		}
+	}
+}
+
+func TestStepsDefaultProject(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "env-project")
+	manifest, err := providerConfigs(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(manifest), `projectID: "env-project"`) {
+		t.Errorf("provider config does not use GCP_PROJECT:\n%s", manifest)
+	}
+}
+
+func TestStepsProviders(t *testing.T) {
+	steps, err := Steps(Options{Providers: []string{"aws", "azure"}})
+	if err != nil {
+		t.Fatalf("Steps: %v", err)
+	}
+	manifests := map[string]string{}
+	waits := []string{}
+	for _, step := range steps {
+		manifests[step.Name] = string(step.Manifest)
+		for _, wait := range step.WaitFor {
+			waits = append(waits, wait.Resource)
+		}
+	}
+	for _, name := range []string{"provider-aws-ec2", "provider-azure-compute", "provider-azure-network"} {
+		if !strings.Contains(manifests["providers and functions"], "name: "+name+"\n") {
+			t.Errorf("provider %s is not installed", name)
+		}
+		if !slices.Contains(waits, "providers.pkg.crossplane.io/"+name) {
+			t.Errorf("bootstrap does not wait for provider %s", name)
+		}
+	}
+	for _, name := range []string{"tanuunodegroup-aws", "tanuunodegroup-azure"} {
+		if !strings.Contains(manifests["compositions"], "name: "+name+"\n") {
+			t.Errorf("composition %s is not installed", name)
+		}
+	}
+	if strings.Contains(manifests["providers and functions"]+manifests["compositions"], "gcp") {
+		t.Error("GCP resources are installed without the gcp provider")
+	}
+	for _, apiVersion := range []string{"aws.upbound.io/v1beta1", "azure.upbound.io/v1beta1"} {
+		if !strings.Contains(manifests["provider config"], "apiVersion: "+apiVersion+"\nkind: ProviderConfig") {
+			t.Errorf("ProviderConfig %s is not installed:\n%s", apiVersion, manifests["provider config"])
+		}
+	}
+}
+
+func TestStepsUnknownProvider(t *testing.T) {
+	if _, err := Steps(Options{Providers: []string{"oracle"}}); err == nil || !strings.Contains(err.Error(), "must be one of aws, azure, gcp") {
+		t.Errorf("Steps() error = %v, want the known providers", err)
+	}
+}
+
+// TestAWSCompositionHostname renders the AWS composition with the functions
+// it uses from sprig. Talos takes the hostname from the user data, which must
+// be the claim ID with a suffix for the machines to be found.
+func TestAWSCompositionHostname(t *testing.T) {
+	data, err := pkg.FS.ReadFile("nodegroupcomp-aws.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composition := struct {
+		Spec struct {
+			Pipeline []struct {
+				Input struct {
+					Inline struct {
+						Template string `json:"template"`
+					} `json:"inline"`
+				} `json:"input"`
+			} `json:"pipeline"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(data, &composition); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := template.New("aws").Funcs(template.FuncMap{
+		"int": func(v interface{}) int { return int(v.(float64)) },
+		"untilStep": func(start, stop, step int) []int {
+			steps := []int{}
+			for i := start; i < stop; i += step {
+				steps = append(steps, i)
+			}
+			return steps
+		},
+	}).Parse(composition.Spec.Pipeline[0].Input.Inline.Template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xr := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(`
+observed:
+  composite:
+    resource:
+      metadata: {uid: 3f9a0c1e-5b7d-4e2a-9c61-0d8e7f6a5b4c}
+      spec: {id: dev-1a2b-worker-group, parameters: {replicas: 2}}
+`), &xr); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, xr); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, document := range strings.Split(out.String(), "\n---\n")[1:] {
+		instance := struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Spec struct {
+				ForProvider struct {
+					UserData string            `json:"userData"`
+					Tags     map[string]string `json:"tags"`
+				} `json:"forProvider"`
+			} `json:"spec"`
+		}{}
+		if err := yaml.Unmarshal([]byte(document), &instance); err != nil {
+			t.Fatalf("%v:\n%s", err, document)
+		}
+		name := instance.Metadata.Name
+		names = append(names, name)
+		// the user data is a complete Talos config document
+		hostname := map[string]string{}
+		if err := yaml.Unmarshal([]byte(instance.Spec.ForProvider.UserData), &hostname); err != nil {
+			t.Fatalf("user data of %s: %v", name, err)
+		}
+		want := map[string]string{"apiVersion": "v1alpha1", "kind": "HostnameConfig", "hostname": name}
+		if !reflect.DeepEqual(hostname, want) {
+			t.Errorf("user data of %s = %v, want %v", name, hostname, want)
+		}
+		if instance.Spec.ForProvider.Tags["Name"] != name {
+			t.Errorf("instance %s has the Name tag %q", name, instance.Spec.ForProvider.Tags["Name"])
+		}
+	}
+	if want := []string{"dev-1a2b-worker-group-3f900", "dev-1a2b-worker-group-3f901"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("instances = %v, want %v", names, want)
+	}
+}
+
+// TestPackageVersions checks that bootstrap installs the versions of the
+// providers and functions the Configuration package depends on, and that
+// every API group the compositions use is served by one of them.
+func TestPackageVersions(t *testing.T) {
+	data, err := pkg.FS.ReadFile("crossplane.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configuration := struct {
+		Spec struct {
+			DependsOn []struct {
+				Provider string `json:"provider"`
+				Function string `json:"function"`
+				Version  string `json:"version"`
+			} `json:"dependsOn"`
+		} `json:"spec"`
+	}{}
+	if err := yaml.Unmarshal(data, &configuration); err != nil {
+		t.Fatal(err)
+	}
+
+	installed := map[string]string{}
+	files, err := manifests.ReadDir("manifests")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := manifests.ReadFile("manifests/" + file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, document := range strings.Split(string(data), "\n---\n") {
+			resource := struct {
+				Spec struct {
+					Package string `json:"package"`
+				} `json:"spec"`
+			}{}
+			if err := yaml.Unmarshal([]byte(document), &resource); err != nil {
+				t.Fatalf("%s: %v", file.Name(), err)
+			}
+			if image, version, ok := strings.Cut(resource.Spec.Package, ":"); ok {
+				installed[image] = version
+			}
+		}
+	}
+	depends := map[string]bool{}
+	for _, dependency := range configuration.Spec.DependsOn {
+		image := dependency.Provider + dependency.Function
+		depends[image[strings.LastIndex(image, "/")+1:]] = true
+		version, ok := installed[image]
+		if !ok {
+			t.Errorf("bootstrap does not install %s", image)
+			continue
+		}
+		if dependency.Version != ">="+version {
+			t.Errorf("%s: crossplane.yaml requires %s, bootstrap installs %s", image, dependency.Version, version)
+		}
+	}
+
+	// the packages serving the API groups of the composed resources
+	served := map[string]string{
+		"compute.gcp.upbound.io":        "provider-gcp-compute",
+		"ec2.aws.upbound.io":            "provider-aws-ec2",
+		"compute.azure.upbound.io":      "provider-azure-compute",
+		"network.azure.upbound.io":      "provider-azure-network",
+		"kubernetes.crossplane.io":      "provider-kubernetes",
+		"gotemplating.fn.crossplane.io": "function-go-templating",
+		"pt.fn.crossplane.io":           "function-patch-and-transform",
+	}
+	// v1alpha1 is the Talos config document in the AWS user data
+	builtin := []string{"v1", "v1alpha1", "apiextensions.crossplane.io/v1", "tanuu.dev/v1alpha1"}
+	apiVersion := regexp.MustCompile(`apiVersion: ([a-z0-9./]+)`)
+	for _, name := range []string{"nodegroupcomp.yaml", "nodegroupcomp-aws.yaml", "nodegroupcomp-azure.yaml"} {
+		data, err := pkg.FS.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range apiVersion.FindAllStringSubmatch(string(data), -1) {
+			if slices.Contains(builtin, match[1]) {
+				continue
+			}
+			group, _, _ := strings.Cut(match[1], "/")
+			if !depends[served[group]] {
+				t.Errorf("%s uses %s, which no package crossplane.yaml depends on serves", name, match[1])
+			}
+		}
+	}
+}
+
+func TestWaitFor(t *testing.T) {
+	wait := "kubectl wait --for=condition=Healthy providers.pkg.crossplane.io/provider-gcp-compute --timeout=1m0s"
+	notFound := utilstest.Response{Stderr: "not found", Err: utilstest.ExitError(1)}
+
+	t.Run("healthy once installed", func(t *testing.T) {
+		runner := utilstest.NewRunner(t, map[string][]utilstest.Response{wait: {notFound, notFound, {}}})
+		clock := utilstest.NewClock()
+		t.Cleanup(utils.SetRunner(runner))
+		t.Cleanup(utils.SetClock(clock))
+		if err := waitFor("providers.pkg.crossplane.io/provider-gcp-compute", "Healthy"); err != nil {
+			t.Fatalf("waitFor() error = %v", err)
+		}
+		if len(runner.Calls) != 3 || clock.Slept != 20*time.Second {
+			t.Errorf("ran %d commands and slept %v, want 3 and 20s", len(runner.Calls), clock.Slept)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		clock := utilstest.NewClock()
+		t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, map[string][]utilstest.Response{wait: {notFound}})))
+		t.Cleanup(utils.SetClock(clock))
+		err := waitFor("providers.pkg.crossplane.io/provider-gcp-compute", "Healthy")
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Fatalf("waitFor() error = %v, want the last error", err)
+		}
+		if clock.Slept != waitTimeout {
+			t.Errorf("slept %v, want %v", clock.Slept, waitTimeout)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+var cleanDryRun bool
+
+// cleanResult is the output of the clean command
+type cleanResult struct {
+	StateDir string   `json:"stateDir"`
+	Removed  []string `json:"removed"`
+}
+
+// cleanCmd removes the local state of environments that no longer exist
+var cleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "remove local artifacts of deleted environments",
+	Long:  `Remove the state directories of environments that have neither an Omni cluster nor node group claims.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		clusters, err := utils.ListClusters()
+		if err != nil {
+			log.Fatalf("Error listing clusters: %v", err)
+		}
+		claims, err := utils.ListClaims()
+		if err != nil {
+			log.Fatalf("Error listing claims: %v", err)
+		}
+		existing := map[string]bool{}
+		for _, cluster := range clusters {
+			existing[cluster] = true
+		}
+		for _, claim := range claims {
+			existing[claim.Environment()] = true
+		}
+		names, err := state.Environments()
+		if err != nil {
+			log.Fatalf("Error reading %s: %v", state.Dir(), err)
+		}
+		result := cleanResult{StateDir: state.Dir(), Removed: []string{}}
+		for _, name := range names {
+			if existing[name] {
+				continue
+			}
+			if !cleanDryRun {
+				if err := state.RemoveEnvironment(name); err != nil {
+					log.Fatalf("Error removing state of %s: %v", name, err)
+				}
+			}
+			result.Removed = append(result.Removed, name)
+		}
+		printResult(format, result, func(w io.Writer) {
+			verb := "Removed"
+			if cleanDryRun {
+				verb = "Would remove"
+			}
+			for _, name := range result.Removed {
+				fmt.Fprintf(w, "%s artifacts of %s\n", verb, name)
+			}
+			fmt.Fprintf(w, "%d of %d environments in %s cleaned.\n", len(result.Removed), len(names), result.StateDir)
+		})
+	},
+}
+
+func init() {
+	cleanCmd.Flags().BoolVar(&cleanDryRun, "dry-run", false, "Only list the artifacts that would be removed")
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/clone"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/cost"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/naming"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/notify"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/progress"
+)
+
+var cloneName string
+
+// cloneCmd creates a new environment with the shape of an existing one
+var cloneCmd = &cobra.Command{
+	Use:   "clone <environment>",
+	Short: "clone an environment",
+	Long:  `Create a new environment with the node groups, versions, extra manifests and config patches of an existing environment.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		environment, err := clone.Shape(args[0], environmentName(cloneName))
+		if err != nil {
+			log.Fatalf("Error reading environment %s: %v", args[0], err)
+		}
+		estimate, err := create.EstimateCost(environment)
+		if err != nil {
+			log.Fatalf("Error estimating cost: %v", err)
+		}
+		estimate.Print(os.Stderr)
+		if warning := estimate.Warning(costThreshold); warning != "" && !assumeYes {
+			if !confirm(warning + ", continue?") {
+				log.Info("Environment clone cancelled")
+				os.Exit(1)
+			}
+		}
+		log.Infof("Cloning %s into %s", args[0], environment.Name)
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), create.Timeout)
+		defer cancel()
+		result, err := create.Createenvironment(ctx, environment, progress.LinePrinter(os.Stderr))
+		if err != nil {
+			notify.Publish(notify.NewEvent(notify.CreateFailed, environment.Name, start, err))
+			log.Fatalf("Error cloning environment: %v", err)
+		}
+		event := notify.NewEvent(notify.Created, result.Name, start, nil)
+		event.Kubeconfig = result.Kubeconfig
+		notify.Publish(event)
+		printResult(format, result, func(w io.Writer) {
+			fmt.Fprintf(w, "Environment %s cloned from %s.\nEndpoint: %s\nKubeconfig: %s\n", result.Name, args[0], result.Endpoint, result.Kubeconfig)
+		})
+	},
+}
+
+func init() {
+	cloneCmd.Flags().StringVarP(&cloneName, "name", "n", "", "Name of the new environment")
+	cloneCmd.MarkFlagRequired("name")
+	cloneCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+	cloneCmd.Flags().Float64Var(&costThreshold, "cost-threshold", cost.Threshold(), "Hourly cost above which to ask for confirmation")
+	cloneCmd.Flags().BoolVar(&exactName, "exact-name", false, "Use the name as given, without the naming template")
+	cloneCmd.Flags().StringVar(&nameTemplate, "name-template", "", "Naming template with {owner}, {name} and {suffix}, defaults to TANUU_NAME_TEMPLATE or "+naming.DefaultTemplate)
+}
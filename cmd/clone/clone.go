@@ -0,0 +1,58 @@
+package clone
+
+import (
+	"strings"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// patchID returns the ID of a config patch of the source for the clone. IDs
+// that name the source name the clone instead, the others get the name of the
+// clone appended, so the clone never shares a patch with the source.
+func patchID(id, source, name string) string {
+	if strings.Contains(id, source) {
+		return strings.ReplaceAll(id, source, name)
+	}
+	return id + "-" + name
+}
+
+// Shape returns the environment `name` with the shape of the environment
+// `source`: its provider, node groups, versions, extra manifests and config
+// patches. Machines and secrets are left for Createenvironment to fill in.
+func Shape(source, name string) (utils.Environment, error) {
+	claims, err := utils.EnvironmentClaims(source)
+	if err != nil {
+		return utils.Environment{}, err
+	}
+	p, err := provider.FromLabels(claims[0].Spec.CompositionSelector.MatchLabels)
+	if err != nil {
+		return utils.Environment{}, err
+	}
+	environment, err := create.LiveCluster(source)
+	if err != nil {
+		return environment, err
+	}
+	environment.Name = name
+	environment.Provider = p.Name()
+	for i, patch := range environment.Patches {
+		environment.Patches[i].ID = patchID(patch.ID, source, name)
+	}
+	for _, claim := range claims {
+		params := claim.Spec.Parameters
+		group := utils.NodeGroup{
+			ID:          name + strings.TrimPrefix(claim.Metadata.Name, source),
+			Role:        claim.Role(),
+			Replicas:    params.Replicas,
+			DiskSize:    params.Size,
+			MachineType: params.MachineType,
+			Image:       params.Image,
+		}
+		if group.Role == "gpu" {
+			environment.Gpu = true
+		}
+		environment.NodeGroups = append(environment.NodeGroups, group)
+	}
+	return environment, nil
+}
@@ -0,0 +1,85 @@
+package clone
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+// claim is a NodeGroupClaim of dev-1a2b in the `kubectl get nodegroupclaims -o json` output
+func claim(name, role string, replicas int) string {
+	labels := `{"tanuu.dev/environment":"dev-1a2b"}`
+	if role != "" {
+		labels = fmt.Sprintf(`{"tanuu.dev/environment":"dev-1a2b","tanuu.dev/role":%q}`, role)
+	}
+	return fmt.Sprintf(`{"metadata":{"name":%q,"labels":%s},"spec":{"compositionSelector":{"matchLabels":{"provider":"google","cluster":"gke"}},`+
+		`"parameters":{"replicas":%d,"size":50,"image":"omni-worker","machineType":"e2-highmem-4"}}}`, name, labels, replicas)
+}
+
+// patch is an Omni config patch of dev-1a2b
+func patch(id, data string) string {
+	return fmt.Sprintf(`{"metadata":{"id":%q,"labels":{"omni.sidero.dev/cluster":"dev-1a2b"}},"spec":{"data":%q}}`, id, data)
+}
+
+func TestShape(t *testing.T) {
+	t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, utilstest.Outputs(map[string]string{
+		"kubectl get nodegroupclaims -o json": `{"items":[` + strings.Join([]string{
+			claim("dev-1a2b-ctlr-group", "ctlr", 1),
+			claim("dev-1a2b-highmem-group", "worker", 3),
+			// created before the role label
+			claim("dev-1a2b-gpu-group", "", 1),
+			// another environment
+			claim("dev-1a2b9-worker-group", "worker", 5),
+		}, ",") + `]}`,
+		"omnictl get cluster dev-1a2b -o json": `{"metadata":{"id":"dev-1a2b"},"spec":{"kubernetesversion":"1.30.1","talosversion":"1.7.4"}}`,
+		"omnictl get configpatches -l omni.sidero.dev/cluster=dev-1a2b -o json": patch("100-dev-1a2b", "cluster:\n  extraManifests:\n  - https://example.com/a.yaml\n") +
+			patch("300-dev-1a2b-sysctl", "machine: {}\n") + patch("500-registry-mirror", "machine: {}\n"),
+	}))))
+
+	environment, err := Shape("dev-1a2b", "bug-42")
+	if err != nil {
+		t.Fatalf("Shape() error = %v", err)
+	}
+	if environment.Name != "bug-42" || environment.Provider != "gcp" || !environment.Gpu {
+		t.Errorf("Shape() = name %s, provider %s, gpu %t", environment.Name, environment.Provider, environment.Gpu)
+	}
+	if environment.KubernetesVersion != "v1.30.1" || environment.TalosVersion != "v1.7.4" {
+		t.Errorf("Shape() versions = %s, %s", environment.KubernetesVersion, environment.TalosVersion)
+	}
+	if !reflect.DeepEqual(environment.ExtraManifests, []string{"https://example.com/a.yaml"}) {
+		t.Errorf("Shape() extra manifests = %v", environment.ExtraManifests)
+	}
+	groups := map[string]string{}
+	for _, group := range environment.NodeGroups {
+		groups[group.ID] = fmt.Sprintf("%s/%d", group.Role, group.Replicas)
+	}
+	want := map[string]string{"bug-42-ctlr-group": "ctlr/1", "bug-42-highmem-group": "worker/3", "bug-42-gpu-group": "gpu/1"}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("Shape() node groups = %v, want %v", groups, want)
+	}
+	ids := []string{}
+	for _, patch := range environment.Patches {
+		ids = append(ids, patch.ID)
+	}
+	if want := []string{"300-bug-42-sysctl", "500-registry-mirror-bug-42"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Shape() patch IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestPatchID(t *testing.T) {
+	tests := []struct {
+		id, want string
+	}{
+		{id: "300-dev-1a2b-sysctl", want: "300-bug-42-sysctl"},
+		{id: "500-registry-mirror", want: "500-registry-mirror-bug-42"},
+	}
+	for _, tt := range tests {
+		if got := patchID(tt.id, "dev-1a2b", "bug-42"); got != tt.want {
+			t.Errorf("patchID(%q) = %q, want %q", tt.id, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/cost"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// costCmd reports the cost accrued by each environment
+var costCmd = &cobra.Command{
+	Use:   "cost",
+	Short: "report the cost of running environments",
+	Long:  `Report the cost each environment has accrued since it was created, based on the price table.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		prices, err := cost.LoadPrices()
+		if err != nil {
+			log.Fatalf("Error loading price table: %v", err)
+		}
+		claims, err := utils.ListClaims()
+		if err != nil {
+			log.Fatalf("Error listing environments: %v", err)
+		}
+		accrued := prices.Accrued(claims, time.Now())
+		printResult(outputFormat(), accrued, func(w io.Writer) {
+			cost.PrintAccrued(w, prices.Currency, accrued)
+		})
+	},
+}
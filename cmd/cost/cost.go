@@ -0,0 +1,199 @@
+package cost
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// hoursPerMonth is the number of hours GCP uses to prorate monthly prices
+const hoursPerMonth = 730
+
+// DefaultThreshold is the hourly cost above which create asks for confirmation
+const DefaultThreshold = 2.0
+
+//go:embed prices.yaml
+var embeddedPrices []byte
+
+// PriceTable holds the hourly machine prices and the monthly disk prices
+type PriceTable struct {
+	Currency     string             `json:"currency"`
+	Region       string             `json:"region"`
+	MachineTypes map[string]float64 `json:"machineTypes"`
+	DiskTypes    map[string]float64 `json:"diskTypes"`
+}
+
+// GroupEstimate is the estimated cost of a single node group
+type GroupEstimate struct {
+	Name        string  `json:"name"`
+	MachineType string  `json:"machineType"`
+	DiskType    string  `json:"diskType"`
+	DiskSize    int     `json:"diskSize"`
+	Replicas    int     `json:"replicas"`
+	Hourly      float64 `json:"hourly"`
+	Priced      bool    `json:"priced"`
+}
+
+// Estimate is the estimated cost of an environment
+type Estimate struct {
+	Currency string          `json:"currency"`
+	Groups   []GroupEstimate `json:"groups"`
+	Hourly   float64         `json:"hourly"`
+}
+
+// Accrued is the cost an environment has accrued since it was created
+type Accrued struct {
+	Environment string    `json:"environment"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Hourly      float64   `json:"hourly"`
+	Total       float64   `json:"total"`
+}
+
+// LoadPrices loads the embedded price table, or the file named by PRICE_TABLE
+func LoadPrices() (PriceTable, error) {
+	table := PriceTable{}
+	data := embeddedPrices
+	if file := os.Getenv("PRICE_TABLE"); file != "" {
+		log.Debug("Loading price table from ", file)
+		override, err := os.ReadFile(file)
+		if err != nil {
+			return table, err
+		}
+		data = override
+	}
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		return table, fmt.Errorf("parsing price table: %w", err)
+	}
+	return table, nil
+}
+
+// Threshold returns the hourly cost above which create asks for confirmation
+func Threshold() float64 {
+	value := os.Getenv("COST_THRESHOLD")
+	if value == "" {
+		return DefaultThreshold
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Warnf("Invalid COST_THRESHOLD %q, using %.2f", value, DefaultThreshold)
+		return DefaultThreshold
+	}
+	return threshold
+}
+
+// groupHourly returns the hourly cost of one node group and whether all its parts are priced
+func (t PriceTable) groupHourly(params utils.NodeGroupParameters) (float64, bool) {
+	machine, machineok := t.MachineTypes[params.MachineType]
+	disk, diskok := t.DiskTypes[path.Base(params.ImageType)]
+	perinstance := machine + disk*float64(params.Size)/hoursPerMonth
+	return perinstance * float64(params.Replicas), machineok && diskok
+}
+
+// Estimate estimates the hourly cost of the given claims
+func (t PriceTable) Estimate(claims []utils.NodeGroupClaim) Estimate {
+	estimate := Estimate{Currency: t.Currency}
+	for _, claim := range claims {
+		params := claim.Spec.Parameters
+		hourly, priced := t.groupHourly(params)
+		if !priced {
+			log.Warnf("No price for machine type %s or disk type %s", params.MachineType, path.Base(params.ImageType))
+		}
+		estimate.Groups = append(estimate.Groups, GroupEstimate{
+			Name:        claim.Metadata.Name,
+			MachineType: params.MachineType,
+			DiskType:    path.Base(params.ImageType),
+			DiskSize:    params.Size,
+			Replicas:    params.Replicas,
+			Hourly:      hourly,
+			Priced:      priced,
+		})
+		estimate.Hourly += hourly
+	}
+	return estimate
+}
+
+// Accrued returns the cost accrued by each environment the claims belong to
+func (t PriceTable) Accrued(claims []utils.NodeGroupClaim, now time.Time) []Accrued {
+	environments := map[string]*Accrued{}
+	for _, claim := range claims {
+		name := claim.Environment()
+		env, ok := environments[name]
+		if !ok {
+			env = &Accrued{Environment: name, CreatedAt: claim.Metadata.CreationTimestamp}
+			environments[name] = env
+		}
+		hourly, _ := t.groupHourly(claim.Spec.Parameters)
+		env.Hourly += hourly
+		env.Total += hourly * now.Sub(claim.Metadata.CreationTimestamp).Hours()
+		if claim.Metadata.CreationTimestamp.Before(env.CreatedAt) {
+			env.CreatedAt = claim.Metadata.CreationTimestamp
+		}
+	}
+	accrued := []Accrued{}
+	for _, env := range environments {
+		accrued = append(accrued, *env)
+	}
+	sort.Slice(accrued, func(i, j int) bool { return accrued[i].Environment < accrued[j].Environment })
+	return accrued
+}
+
+// Warning returns why creating the estimated environment needs confirmation:
+// its cost exceeds the threshold, or node groups without a price may make it
+// exceed the threshold. It is empty when no confirmation is needed.
+func (e Estimate) Warning(threshold float64) string {
+	if e.Hourly > threshold {
+		return fmt.Sprintf("Estimated cost of %.2f %s/hour exceeds %.2f %s/hour", e.Hourly, e.Currency, threshold, e.Currency)
+	}
+	unpriced := []string{}
+	for _, group := range e.Groups {
+		if !group.Priced {
+			unpriced = append(unpriced, group.Name)
+		}
+	}
+	if len(unpriced) > 0 {
+		return fmt.Sprintf("No price for %s, the cost may exceed %.2f %s/hour", strings.Join(unpriced, ", "), threshold, e.Currency)
+	}
+	return ""
+}
+
+// Daily returns the estimated daily cost
+func (e Estimate) Daily() float64 {
+	return e.Hourly * 24
+}
+
+// Print writes a human readable table of the estimate
+func (e Estimate) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tMACHINE\tDISK\tREPLICAS\tHOURLY")
+	for _, group := range e.Groups {
+		hourly := fmt.Sprintf("%.2f", group.Hourly)
+		if !group.Priced {
+			hourly += " (incomplete)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s %dGB\t%d\t%s\n", group.Name, group.MachineType, group.DiskType, group.DiskSize, group.Replicas, hourly)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Estimated cost: %.2f %s/hour, %.2f %s/day\n", e.Hourly, e.Currency, e.Daily(), e.Currency)
+}
+
+// PrintAccrued writes a human readable table of the accrued costs
+func PrintAccrued(w io.Writer, currency string, accrued []Accrued) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ENVIRONMENT\tCREATED\tHOURLY\tACCRUED")
+	for _, env := range accrued {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f %s\t%.2f %s\n", env.Environment, env.CreatedAt.Format(time.RFC3339), env.Hourly, currency, env.Total, currency)
+	}
+	tw.Flush()
+}
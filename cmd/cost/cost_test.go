@@ -0,0 +1,249 @@
+package cost
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+func TestEmbeddedPricesCoverProviders(t *testing.T) {
+	// aws and azure have no default images or network
+	for _, role := range []string{"WORKER", "CTLR", "GPU"} {
+		t.Setenv("AWS_IMAGE_"+role, "ami-0123456789abcdef0")
+		t.Setenv("AZURE_IMAGE_"+role, "talos")
+	}
+	t.Setenv("AZURE_SUBNET_ID", "subnet")
+	t.Setenv("AZURE_SSH_PUBLIC_KEY", "ssh-ed25519 AAAA test")
+	table, err := LoadPrices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range provider.Names() {
+		p, err := provider.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []utils.Size{utils.SizeSmall, utils.SizeMedium, utils.SizeLarge, utils.SizeGPU} {
+			params, err := p.Parameters(utils.NodeGroup{Role: "worker", Replicas: 1, Size: size})
+			if err != nil {
+				t.Fatalf("%s %s: %v", name, size, err)
+			}
+			if _, priced := table.groupHourly(params); !priced {
+				t.Errorf("%s has no price for machine type %s or disk type %s", name, params.MachineType, params.ImageType)
+			}
+		}
+	}
+}
+
+func TestWarning(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate Estimate
+		want     string
+	}{
+		{name: "below the threshold", estimate: Estimate{Currency: "USD", Hourly: 1.5, Groups: []GroupEstimate{{Name: "a", Priced: true}}}},
+		{name: "above the threshold", estimate: Estimate{Currency: "USD", Hourly: 2.5, Groups: []GroupEstimate{{Name: "a", Priced: true}}}, want: "Estimated cost of 2.50 USD/hour exceeds 2.00 USD/hour"},
+		{name: "unpriced group", estimate: Estimate{Currency: "USD", Hourly: 0, Groups: []GroupEstimate{{Name: "a", Priced: true}, {Name: "b"}}}, want: "No price for b, the cost may exceed 2.00 USD/hour"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.estimate.Warning(2); got != tt.want {
+				t.Errorf("Warning() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintIncomplete(t *testing.T) {
+	var out strings.Builder
+	Estimate{Currency: "USD", Groups: []GroupEstimate{{Name: "a", MachineType: "m", DiskType: "d"}}}.Print(&out)
+	if !strings.Contains(out.String(), "(incomplete)") {
+		t.Errorf("Print() does not mark the unpriced group:\n%s", out.String())
+	}
+}
+
+// table is a price table with round numbers
+var table = PriceTable{
+	Currency:     "USD",
+	MachineTypes: map[string]float64{"small": 0.10, "big": 1.00},
+	DiskTypes:    map[string]float64{"pd-balanced": 0.73},
+}
+
+// claim is a NodeGroupClaim of the environment created at the time
+func claim(name, environment, machineType string, replicas int, created time.Time) utils.NodeGroupClaim {
+	c := utils.NodeGroupClaim{}
+	c.Metadata.Name = name
+	c.Metadata.Labels = map[string]string{utils.EnvironmentLabel: environment}
+	c.Metadata.CreationTimestamp = created
+	c.Spec.Parameters = utils.NodeGroupParameters{
+		Replicas:    replicas,
+		Size:        100,
+		MachineType: machineType,
+		ImageType:   "projects/p/zones/z/diskTypes/pd-balanced",
+	}
+	return c
+}
+
+// near compares costs without rounding errors
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestLoadPrices(t *testing.T) {
+	embedded, err := LoadPrices()
+	if err != nil {
+		t.Fatalf("LoadPrices() error = %v", err)
+	}
+	if embedded.Currency != "USD" || embedded.MachineTypes["e2-highmem-4"] == 0 || embedded.DiskTypes["pd-balanced"] == 0 {
+		t.Errorf("embedded prices = %+v", embedded)
+	}
+
+	dir := t.TempDir()
+	override := filepath.Join(dir, "prices.yaml")
+	if err := os.WriteFile(override, []byte("currency: EUR\nmachineTypes:\n  custom-4: 0.5\ndiskTypes:\n  pd-ssd: 0.2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := os.WriteFile(invalid, []byte("machineTypes: [1, 2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		file    string
+		machine string
+		price   float64
+		err     string
+	}{
+		{name: "override", file: override, machine: "custom-4", price: 0.5},
+		{name: "missing file", file: filepath.Join(dir, "missing.yaml"), err: "no such file"},
+		{name: "invalid file", file: invalid, err: "parsing price table"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PRICE_TABLE", tt.file)
+			prices, err := LoadPrices()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("LoadPrices() error = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadPrices() error = %v", err)
+			}
+			if prices.Currency != "EUR" || prices.MachineTypes[tt.machine] != tt.price || len(prices.MachineTypes) != 1 {
+				t.Errorf("LoadPrices() = %+v, want only the override", prices)
+			}
+		})
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 100 GB of pd-balanced cost 0.73 * 100 / 730 = 0.10 per instance and hour
+	tests := []struct {
+		name   string
+		claims []utils.NodeGroupClaim
+		hourly float64
+		priced []bool
+	}{
+		{name: "no claims"},
+		{
+			name:   "priced",
+			claims: []utils.NodeGroupClaim{claim("dev-ctlr-group", "dev", "small", 1, created), claim("dev-worker-group", "dev", "big", 2, created)},
+			hourly: (0.10 + 0.10) + 2*(1.00+0.10),
+			priced: []bool{true, true},
+		},
+		{
+			name:   "unpriced machine type",
+			claims: []utils.NodeGroupClaim{claim("dev-ctlr-group", "dev", "small", 1, created), claim("dev-gpu-group", "dev", "a100", 1, created)},
+			hourly: (0.10 + 0.10) + 0.10,
+			priced: []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			estimate := table.Estimate(tt.claims)
+			if !near(estimate.Hourly, tt.hourly) || !near(estimate.Daily(), tt.hourly*24) {
+				t.Errorf("Estimate() hourly = %v, daily = %v, want %v", estimate.Hourly, estimate.Daily(), tt.hourly)
+			}
+			if estimate.Currency != "USD" || len(estimate.Groups) != len(tt.priced) {
+				t.Fatalf("Estimate() = %+v", estimate)
+			}
+			for i, group := range estimate.Groups {
+				if group.Priced != tt.priced[i] || group.DiskType != "pd-balanced" {
+					t.Errorf("group %s priced = %t, disk type %s, want %t, pd-balanced", group.Name, group.Priced, group.DiskType, tt.priced[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAccrued(t *testing.T) {
+	now := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		claims []utils.NodeGroupClaim
+		want   []Accrued
+	}{
+		{name: "no claims", want: []Accrued{}},
+		{
+			name:   "one environment for a day",
+			claims: []utils.NodeGroupClaim{claim("dev-ctlr-group", "dev", "small", 1, now.Add(-24*time.Hour))},
+			want:   []Accrued{{Environment: "dev", CreatedAt: now.Add(-24 * time.Hour), Hourly: 0.20, Total: 0.20 * 24}},
+		},
+		{
+			name: "groups added later accrue from their creation",
+			claims: []utils.NodeGroupClaim{
+				claim("dev-worker-group", "dev", "big", 1, now.Add(-2*time.Hour)),
+				claim("dev-ctlr-group", "dev", "small", 1, now.Add(-10*time.Hour)),
+				claim("ci-ctlr-group", "ci", "small", 2, now.Add(-30*time.Minute)),
+			},
+			want: []Accrued{
+				{Environment: "ci", CreatedAt: now.Add(-30 * time.Minute), Hourly: 0.40, Total: 0.40 * 0.5},
+				{Environment: "dev", CreatedAt: now.Add(-10 * time.Hour), Hourly: 1.10 + 0.20, Total: 1.10*2 + 0.20*10},
+			},
+		},
+		{
+			name:   "unpriced machine types accrue only their disks",
+			claims: []utils.NodeGroupClaim{claim("dev-gpu-group", "dev", "a100", 1, now.Add(-10*time.Hour))},
+			want:   []Accrued{{Environment: "dev", CreatedAt: now.Add(-10 * time.Hour), Hourly: 0.10, Total: 0.10 * 10}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := table.Accrued(tt.claims, now)
+			if len(got) != len(tt.want) {
+				t.Fatalf("Accrued() = %+v, want %+v", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if got[i].Environment != want.Environment || !got[i].CreatedAt.Equal(want.CreatedAt) || !near(got[i].Hourly, want.Hourly) || !near(got[i].Total, want.Total) {
+					t.Errorf("Accrued()[%d] = %+v, want %+v", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	tests := []struct {
+		value string
+		want  float64
+	}{
+		{value: "", want: DefaultThreshold},
+		{value: "5.5", want: 5.5},
+		{value: "lots", want: DefaultThreshold},
+	}
+	for _, tt := range tests {
+		t.Setenv("COST_THRESHOLD", tt.value)
+		if got := Threshold(); got != tt.want {
+			t.Errorf("Threshold() with COST_THRESHOLD=%q = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -3,146 +3,310 @@ package create
 import (
 	"bytes"
 	"context"
-	"embed"
+	"fmt"
 	"os"
 	"os/exec"
-	"strings"
-	"text/template"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/bootstrap"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/cost"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/naming"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
 	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
 )
 
-//go:embed templates/*
-var tplFolder embed.FS
-
-// Declare type pointer to a template
-var claimbasetemp *template.Template
-var claimgputemp *template.Template
-var clustertemp *template.Template
-var kubeconfigtemp *template.Template
-
-// Using the init function to make sure the template is only parsed once in the program
-func init() {
-	// template.Must takes the reponse of template.ParseFiles and does ertemplror checking
-	claimbasetemp = template.Must(template.ParseFS(tplFolder, "templates/claim-base.tmpl"))
-	claimgputemp = template.Must(template.ParseFS(tplFolder, "templates/claim-gpu.tmpl"))
-	clustertemp = template.Must(template.ParseFS(tplFolder, "templates/cluster.tmpl"))
-	kubeconfigtemp = template.Must(template.ParseFS(tplFolder, "templates/kubeconfig.tmpl"))
+const (
+	// DefaultKubernetesVersion is the Kubernetes version of new environments
+	DefaultKubernetesVersion = "v1.29.4"
+	// DefaultTalosVersion is the Talos version of new environments
+	DefaultTalosVersion = "v1.6.7"
+)
+
+// DefaultExtraManifests are the manifests every cluster applies on boot
+var DefaultExtraManifests = []string{
+	"https://api.github.com/repos/silogen/cluster-init/contents/k8s-tailscale-users.yaml",
+	"https://api.github.com/repos/silogen/cluster-init/contents/nvidia.yaml",
+	"https://api.github.com/repos/silogen/cluster-init/contents/tailscale.yaml",
 }
 
-// Createenvironment creates an environment
-func Createenvironment(ctx context.Context, environment utils.Environment) error {
-	log.Info("Creating environment with name: ", environment.Name)
-	environment.TailScaleClientID = os.Getenv("TAILSCALE_CLIENT_ID")
-	environment.TailScaleClientSecret = os.Getenv("TAILSCALE_CLIENT_SECRET")
-	// Execute the template with the environment struct
-	claimfile, err := os.OpenFile(environment.Name+"-composition.yaml", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+// NodeGroups returns the node groups of the environment with their IDs filled in
+func NodeGroups(environment utils.Environment) []utils.NodeGroup {
+	groups := environment.NodeGroups
+	if len(groups) == 0 {
+		groups = provider.DefaultNodeGroups(environment.Gpu)
+	}
+	named := []utils.NodeGroup{}
+	for _, group := range groups {
+		if group.ID == "" {
+			group.ID = environment.Name + "-" + group.Role + "-group"
+		}
+		named = append(named, group)
+	}
+	return named
+}
+
+// Instances returns the number of VM instances each node group of the environment creates
+func Instances(environment utils.Environment) ([]utils.GroupInstances, error) {
+	p, err := provider.Get(environment.Provider)
+	if err != nil {
+		return nil, err
+	}
+	groups := []utils.GroupInstances{}
+	for _, group := range NodeGroups(environment) {
+		groups = append(groups, utils.GroupInstances{Claim: group.ID, Kind: p.InstanceKind(), Role: group.Role, Replicas: group.Replicas})
+	}
+	return groups, nil
+}
+
+// EstimateCost estimates the cost of the node groups the environment will create
+func EstimateCost(environment utils.Environment) (cost.Estimate, error) {
+	var claims bytes.Buffer
+	if err := RenderClaims(&claims, environment); err != nil {
+		return cost.Estimate{}, err
+	}
+	parsed, err := utils.ParseClaims(&claims)
+	if err != nil {
+		return cost.Estimate{}, err
+	}
+	prices, err := cost.LoadPrices()
 	if err != nil {
-		log.Fatalf("Error opening claimfile: %v", err)
+		return cost.Estimate{}, err
+	}
+	return prices.Estimate(parsed), nil
+}
+
+// CheckName checks that the hostnames of a new environment fit the limits and
+// that no Omni cluster or NodeGroupClaim already uses its name
+func CheckName(environment utils.Environment) error {
+	groups := NodeGroups(environment)
+	ids := []string{}
+	for _, group := range groups {
+		ids = append(ids, group.ID)
+	}
+	if err := naming.CheckHostnames(environment.Name, ids); err != nil {
 		return err
 	}
-	defer claimfile.Close()
-	clusterfile, err := os.OpenFile(environment.Name+"-cluster.yaml", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	clusters, err := utils.ListClusters()
 	if err != nil {
-		log.Fatalf("Error opening clusterfile: %v", err)
 		return err
 	}
-	defer clusterfile.Close()
-	kubeconfigfile, err := os.OpenFile(environment.Name+".kubeconfig", os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+	for _, cluster := range clusters {
+		if cluster == environment.Name {
+			return fmt.Errorf("an Omni cluster named %s already exists", environment.Name)
+		}
+	}
+	claims, err := utils.ListClaims()
 	if err != nil {
-		log.Fatalf("Error opening kubeconfigfile: %v", err)
 		return err
 	}
-	defer kubeconfigfile.Close()
-	if environment.Gpu {
-		err = claimgputemp.Execute(claimfile, environment)
-		if err != nil {
-			log.Fatalf("Error executing template: %v", err)
-			return err
+	for _, claim := range claims {
+		for _, id := range ids {
+			if claim.Metadata.Name == id {
+				return fmt.Errorf("node group %s already exists", id)
+			}
+		}
+		if claim.Environment() == environment.Name {
+			return fmt.Errorf("environment %s already has node group %s", environment.Name, claim.Metadata.Name)
 		}
-	} else {
-		err = claimbasetemp.Execute(claimfile, environment)
-		if err != nil {
-			log.Fatalf("Error executing template: %v", err)
+	}
+	return nil
+}
+
+// ResolveSecrets fills in the secrets the cluster template needs.
+// It fails when one of them is missing from all secret sources.
+func ResolveSecrets(environment *utils.Environment) error {
+	values, err := secrets.Resolve(secrets.Required...)
+	if err != nil {
+		return err
+	}
+	environment.TailScaleClientID = values["TAILSCALE_CLIENT_ID"]
+	environment.TailScaleClientSecret = values["TAILSCALE_CLIENT_SECRET"]
+	environment.GitHubToken = values["GITHUB_TOKEN"]
+	return nil
+}
+
+// SyncCluster renders the Omni cluster template for the machines of each role
+// and syncs it to Omni.
+func SyncCluster(environment utils.Environment, machines map[string][]string) error {
+	if environment.TailScaleClientID == "" || environment.TailScaleClientSecret == "" || environment.GitHubToken == "" {
+		if err := ResolveSecrets(&environment); err != nil {
 			return err
 		}
 	}
+	var cluster bytes.Buffer
+	if err := RenderCluster(&cluster, environment, machines); err != nil {
+		log.Errorf("Error executing template: %v", err)
+		return err
+	}
+	if err := state.WriteFile(environment.Name, state.ClusterFile, cluster.Bytes()); err != nil {
+		log.Errorf("Error writing clusterfile: %v", err)
+		return err
+	}
+	// apply the omni template
+	err := utils.ApplyCluster(environment)
+	// the file is kept for debugging, without the secrets
+	redactFile(environment.Name, state.ClusterFile)
+	return err
+}
+
+// redactFile replaces the secrets in a rendered artifact
+func redactFile(name, file string) {
+	data, err := os.ReadFile(state.Path(name, file))
+	if err != nil {
+		return
+	}
+	if err := state.WriteFile(name, file, []byte(secrets.Redact(string(data)))); err != nil {
+		log.Errorf("Error redacting %s: %v", file, err)
+	}
+}
+
+// Result describes a created environment
+type Result struct {
+	Name       string              `json:"name"`
+	Kubeconfig string              `json:"kubeconfig"`
+	Endpoint   string              `json:"endpoint"`
+	Machines   map[string][]string `json:"machines"`
+}
+
+// Timeout bounds a whole Createenvironment, longer than its waits together
+const Timeout = utils.ReadyTimeout + utils.MachinesTimeout + utils.SyncTimeout + utils.ClusterTimeout + 10*time.Minute
+
+// Createenvironment creates an environment. Every wait stops when ctx is done,
+// so ctx bounds the whole creation.
+// Progress events are published to the log and to the given observers.
+func Createenvironment(ctx context.Context, environment utils.Environment, observers ...Observer) (Result, error) {
+	log.Info("Creating environment with name: ", environment.Name)
+	events := newPublisher(environment.Name, observers)
+	result := Result{Name: environment.Name, Kubeconfig: state.Path(environment.Name, state.KubeconfigFile)}
+	environment.Endpoint = utils.Endpoint(environment.Name)
+	result.Endpoint = environment.Endpoint
+	events.start(PhaseClaims, "")
+	// resolve the secrets first, so a missing secret fails before any VM is created
+	if err := ResolveSecrets(&environment); err != nil {
+		return result, events.fail(PhaseClaims, err)
+	}
+	if err := bootstrap.CheckInstalled(); err != nil {
+		return result, events.fail(PhaseClaims, err)
+	}
+	if err := CheckName(environment); err != nil {
+		return result, events.fail(PhaseClaims, err)
+	}
+	// Execute the template with the environment struct
+	claimfile, err := state.Create(environment.Name, state.CompositionFile)
+	if err != nil {
+		log.Errorf("Error opening claimfile: %v", err)
+		return result, events.fail(PhaseClaims, err)
+	}
+	defer claimfile.Close()
+	kubeconfigfile, err := state.Create(environment.Name, state.KubeconfigFile)
+	if err != nil {
+		log.Errorf("Error opening kubeconfigfile: %v", err)
+		return result, events.fail(PhaseClaims, err)
+	}
+	defer kubeconfigfile.Close()
+	err = RenderClaims(claimfile, environment)
+	if err != nil {
+		log.Errorf("Error executing template: %v", err)
+		return result, events.fail(PhaseClaims, err)
+	}
 	// check that fine kubeconfig exists
 	if _, err := os.Stat("kubeconfig"); os.IsNotExist(err) {
-		log.Fatalf("kubeconfig file does not exist: %v", err)
-		return err
+		log.Errorf("kubeconfig file does not exist: %v", err)
+		return result, events.fail(PhaseClaims, err)
 	}
 	// commandstring := "KUBECONFIG=kubeconfig kubectl apply -f " + environment.Name + "-composition.yaml"
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	apply, cancel := context.WithTimeout(ctx, 5*time.Minute) // Set your desired timeout
 	defer cancel()
-	cmd := exec.CommandContext(ctx, "kubectl", "apply", "-f", environment.Name+"-composition.yaml")
+	cmd := exec.CommandContext(apply, "kubectl", "apply", "-f", claimfile.Name())
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 	// cmd.Stdout = os.Stdout
 	err = cmd.Run()
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Fatalf("Command timed out: %v", ctx.Err())
-		return ctx.Err()
+	if apply.Err() != nil {
+		log.Errorf("Command stopped: %v", apply.Err())
+		return result, events.fail(PhaseClaims, apply.Err())
 	}
 
 	if err != nil {
-		log.Fatalf("Error creating environment: %v, stderr: %s", err, stderr.String())
-		return err
+		log.Errorf("Error creating environment: %v, stderr: %s", err, stderr.String())
+		return result, events.fail(PhaseClaims, err)
+	}
+	events.finish(PhaseClaims, "")
+
+	groups, err := Instances(environment)
+	if err != nil {
+		return result, events.fail(PhaseVMs, err)
+	}
+	expected := 0
+	for _, group := range groups {
+		expected += group.Replicas
 	}
-	utils.WaitForReady(environment.Name)
+	events.start(PhaseVMs, fmt.Sprintf("0/%d ready", expected))
+	ready := map[string]bool{}
+	err = utils.WaitForReady(ctx, groups, utils.ReadyTimeout, func(resource, status string) {
+		events.condition(resource, status)
+		ready[resource] = status == "True"
+		count := 0
+		for _, isready := range ready {
+			if isready {
+				count++
+			}
+		}
+		events.progress(PhaseVMs, fmt.Sprintf("%d/%d ready", count, expected))
+	})
+	if err != nil {
+		return result, events.fail(PhaseVMs, err)
+	}
+	events.finish(PhaseVMs, fmt.Sprintf("%d/%d ready", expected, expected))
 	log.Debug("nodes are ready")
-	// get the omni node ID's
-	time.Sleep(30 * time.Second)
-	err = nil
-	nodes, err := utils.FindReadyNodes(environment.Name)
+
+	events.start(PhaseMachines, "")
+	// get the omni node ID's once every VM has registered
+	nodes, err := utils.WaitForMachines(ctx, environment.Name, groups, utils.MachinesTimeout)
 	if err != nil {
-		log.Fatalf("Error finding ready nodes: %v", err)
-		return err
+		log.Errorf("Error finding ready nodes: %v", err)
+		return result, events.fail(PhaseMachines, err)
 	}
 	log.Debug("Nodes: ", nodes)
-	gpulist := []string{}
-	workerlist := []string{}
-	ctlrlist := []string{}
 	for _, node := range nodes {
 		log.Debug("Node: ", node.Metadata.ID, " Hostname: ", node.Spec.Platformmetadata.Hostname)
-		if strings.Contains(node.Spec.Platformmetadata.Hostname, "gpu") {
-			gpulist = append(gpulist, "  - "+node.Metadata.ID)
-		} else if strings.Contains(node.Spec.Platformmetadata.Hostname, "worker") {
-			workerlist = append(workerlist, "  - "+node.Metadata.ID)
-		} else if strings.Contains(node.Spec.Platformmetadata.Hostname, "ctlr") {
-			ctlrlist = append(ctlrlist, "  - "+node.Metadata.ID)
-		}
+		events.machine(node.Metadata.ID, node.Spec.Platformmetadata.Hostname)
 	}
+	events.finish(PhaseMachines, fmt.Sprintf("%d machines", len(nodes)))
 
-	environment.ControlPlane = strings.Join(ctlrlist, "\n")
-	environment.Workers = strings.Join(workerlist, "\n")
-	environment.Gpus = strings.Join(gpulist, "\n")
-	environment.GitHubToken = os.Getenv("GITHUB_TOKEN")
-	log.Debug("Control Plane: ", environment.ControlPlane)
-	log.Debug("Workers: ", environment.Workers)
-	log.Debug("Gpus: ", environment.Gpus)
-	err = clustertemp.Execute(clusterfile, environment)
+	events.start(PhaseTemplate, "")
+	result.Machines = utils.MachinesByGroup(groups, nodes)
+	err = SyncCluster(environment, result.Machines)
 	if err != nil {
-		log.Fatalf("Error executing template: %v", err)
-		return err
+		return result, events.fail(PhaseTemplate, err)
 	}
-	// apply the omni template
-	utils.ApplyCluster(environment)
-	time.Sleep(30 * time.Second)
-	err = kubeconfigtemp.Execute(kubeconfigfile, environment)
+	if err := utils.WaitForClusterMachines(ctx, environment.Name, len(nodes)); err != nil {
+		return result, events.fail(PhaseTemplate, err)
+	}
+	events.finish(PhaseTemplate, "")
+
+	events.start(PhaseCluster, "")
+	err = utils.WaitForCluster(ctx, environment)
 	if err != nil {
-		log.Fatalf("Error executing template: %v", err)
-		return err
+		return result, events.fail(PhaseCluster, err)
 	}
-	utils.WaitForCluster(environment)
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel != "Debug" {
-		os.Remove(environment.Name + "-composition.yaml")
-		os.Remove(environment.Name + "-cluster.yaml")
+	events.finish(PhaseCluster, "")
+
+	events.start(PhaseKubeconfig, "")
+	err = RenderKubeconfig(kubeconfigfile, environment)
+	if err != nil {
+		log.Errorf("Error executing template: %v", err)
+		return result, events.fail(PhaseKubeconfig, err)
 	}
-	return nil
+	events.finish(PhaseKubeconfig, result.Kubeconfig)
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		state.Remove(environment.Name, state.CompositionFile)
+		state.Remove(environment.Name, state.ClusterFile)
+	}
+	return result, nil
 }
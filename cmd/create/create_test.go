@@ -0,0 +1,180 @@
+package create
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/validate"
+)
+
+func TestRenderClaimsProviders(t *testing.T) {
+	for _, key := range []string{"GCP_PROJECT", "GCP_ZONE", "GCP_DISK_TYPE", "AWS_REGION", "AWS_ZONE", "AWS_VOLUME_TYPE", "AZURE_LOCATION", "AZURE_STORAGE_TYPE"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AWS_IMAGE_WORKER", "ami-worker")
+	t.Setenv("AWS_IMAGE_CTLR", "ami-ctlr")
+	t.Setenv("AWS_IMAGE_GPU", "ami-gpu")
+	t.Setenv("AZURE_IMAGE_WORKER", "/images/worker")
+	t.Setenv("AZURE_IMAGE_CTLR", "/images/ctlr")
+	t.Setenv("AZURE_IMAGE_GPU", "/images/gpu")
+	t.Setenv("AZURE_SUBNET_ID", "/subnets/default")
+	t.Setenv("AZURE_SSH_PUBLIC_KEY", "ssh-ed25519 AAAA talos@tanuu")
+
+	tests := []struct {
+		provider string
+		labels   map[string]string
+		gpu      string
+		check    func(t *testing.T, params utils.NodeGroupParameters)
+	}{
+		{
+			provider: "gcp",
+			labels:   map[string]string{"provider": "google", "cluster": "gke"},
+			gpu:      "g2-standard-24",
+			check: func(t *testing.T, params utils.NodeGroupParameters) {
+				if params.ImageType != "projects/silogen-sandbox/zones/europe-west4-a/diskTypes/pd-balanced" {
+					t.Errorf("imageType = %q", params.ImageType)
+				}
+				if params.ServiceAccountEmail == "" || params.Zone != "europe-west4-a" {
+					t.Errorf("missing gcp parameters: %+v", params)
+				}
+			},
+		},
+		{
+			provider: "aws",
+			labels:   map[string]string{"provider": "aws", "cluster": "ec2"},
+			gpu:      "g6.8xlarge",
+			check: func(t *testing.T, params utils.NodeGroupParameters) {
+				if params.Region != "eu-west-1" || params.Zone != "eu-west-1a" || params.ImageType != "gp3" {
+					t.Errorf("unexpected aws parameters: %+v", params)
+				}
+				if params.ServiceAccountEmail != "" {
+					t.Errorf("aws claim has a service account: %q", params.ServiceAccountEmail)
+				}
+			},
+		},
+		{
+			provider: "azure",
+			labels:   map[string]string{"provider": "azure", "cluster": "vm"},
+			gpu:      "Standard_NC24ads_A100_v4",
+			check: func(t *testing.T, params utils.NodeGroupParameters) {
+				if params.Zone != "westeurope" || params.SubnetID != "/subnets/default" || params.ImageType != "Premium_LRS" {
+					t.Errorf("unexpected azure parameters: %+v", params)
+				}
+				if params.SSHPublicKey != "ssh-ed25519 AAAA talos@tanuu" {
+					t.Errorf("sshPublicKey = %q", params.SSHPublicKey)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			environment := utils.Environment{Name: "test-1a2b", Gpu: true, Provider: tt.provider}
+			var out bytes.Buffer
+			if err := RenderClaims(&out, environment); err != nil {
+				t.Fatalf("RenderClaims: %v", err)
+			}
+			claims, err := utils.ParseClaims(&out)
+			if err != nil {
+				t.Fatalf("ParseClaims: %v\n%s", err, out.String())
+			}
+			if len(claims) != 3 {
+				t.Fatalf("got %d claims, want 3", len(claims))
+			}
+			for _, claim := range claims {
+				if claim.Metadata.Name != claim.Spec.ID {
+					t.Errorf("claim name %q does not match id %q", claim.Metadata.Name, claim.Spec.ID)
+				}
+				for key, value := range tt.labels {
+					if claim.Spec.CompositionSelector.MatchLabels[key] != value {
+						t.Errorf("%s: label %s = %q, want %q", claim.Metadata.Name, key, claim.Spec.CompositionSelector.MatchLabels[key], value)
+					}
+				}
+				tt.check(t, claim.Spec.Parameters)
+			}
+			gpu := claims[2]
+			if gpu.Metadata.Name != "test-1a2b-gpu-group" || gpu.Spec.Parameters.MachineType != tt.gpu {
+				t.Errorf("gpu group = %s with %s, want test-1a2b-gpu-group with %s", gpu.Metadata.Name, gpu.Spec.Parameters.MachineType, tt.gpu)
+			}
+		})
+	}
+}
+
+func TestRenderClaimsMissingImage(t *testing.T) {
+	t.Setenv("AWS_IMAGE_WORKER", "")
+	environment := utils.Environment{Name: "test-1a2b", Provider: "aws"}
+	var out bytes.Buffer
+	if err := RenderClaims(&out, environment); err == nil {
+		t.Fatal("expected an error for aws without images")
+	}
+}
+
+func TestRenderClaimsUnknownProvider(t *testing.T) {
+	environment := utils.Environment{Name: "test-1a2b", Provider: "openstack"}
+	var out bytes.Buffer
+	if err := RenderClaims(&out, environment); err == nil {
+		t.Fatal("expected an error for an unknown provider")
+	}
+}
+
+func TestRenderClaimsKeepsMachineTypeAndImage(t *testing.T) {
+	environment := utils.Environment{Name: "copy-3c4d", Provider: "gcp", NodeGroups: []utils.NodeGroup{
+		{ID: "copy-3c4d-worker-group", Role: "worker", Replicas: 3, DiskSize: 100, MachineType: "n2-standard-16", Image: "projects/other/images/talos"},
+	}}
+	var out bytes.Buffer
+	if err := RenderClaims(&out, environment); err != nil {
+		t.Fatalf("RenderClaims: %v", err)
+	}
+	claims, err := utils.ParseClaims(&out)
+	if err != nil {
+		t.Fatalf("ParseClaims: %v\n%s", err, out.String())
+	}
+	params := claims[0].Spec.Parameters
+	if params.Replicas != 3 || params.Size != 100 || params.MachineType != "n2-standard-16" || params.Image != "projects/other/images/talos" {
+		t.Errorf("unexpected parameters: %+v", params)
+	}
+}
+
+func TestClusterTemplatePatches(t *testing.T) {
+	environment := utils.Environment{
+		Name:           "copy-3c4d",
+		ExtraManifests: []string{"https://example.com/a.yaml"},
+		Patches:        []utils.ConfigPatch{{ID: "500-copy-3c4d", Data: "machine:\n  sysctls:\n    vm.max_map_count: \"262144\"\n"}},
+	}
+	var out bytes.Buffer
+	if err := clustertemp.Execute(&out, environment); err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	for _, want := range []string{
+		"          - \"https://example.com/a.yaml\"\n",
+		"  - idOverride: 500-copy-3c4d\n    inline:\n      machine:\n        sysctls:\n          vm.max_map_count: \"262144\"\n",
+	} {
+		if !bytes.Contains(out.Bytes(), []byte(want)) {
+			t.Errorf("cluster template is missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestClusterTemplateValidates(t *testing.T) {
+	for _, gpu := range []bool{false, true} {
+		environment := utils.Environment{
+			Name:              "test-1a2b",
+			KubernetesVersion: DefaultKubernetesVersion,
+			TalosVersion:      DefaultTalosVersion,
+			ExtraManifests:    DefaultExtraManifests,
+			ControlPlane:      machineList([]string{"ctlr-1"}),
+			Workers:           machineList([]string{"worker-1", "worker-2"}),
+		}
+		if gpu {
+			environment.Gpus = machineList([]string{"gpu-1"})
+		}
+		var out bytes.Buffer
+		if err := clustertemp.Execute(&out, environment); err != nil {
+			t.Fatalf("Execute: %v", err)
+		}
+		if err := validate.ClusterTemplate(out.Bytes()); err != nil {
+			t.Errorf("gpu=%t: %v\n%s", gpu, err, out.String())
+		}
+	}
+}
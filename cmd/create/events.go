@@ -0,0 +1,174 @@
+package create
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Phase is a step of the environment creation
+type Phase string
+
+const (
+	// PhaseClaims applies the NodeGroupClaims to the ops cluster
+	PhaseClaims Phase = "Claims applied"
+	// PhaseVMs waits for the VMs to be provisioned
+	PhaseVMs Phase = "VMs provisioning"
+	// PhaseMachines waits for the machines to register in Omni
+	PhaseMachines Phase = "Machines registered in Omni"
+	// PhaseTemplate syncs the Omni cluster template
+	PhaseTemplate Phase = "Cluster template synced"
+	// PhaseCluster waits for the cluster to be RUNNING
+	PhaseCluster Phase = "Cluster RUNNING"
+	// PhaseKubeconfig writes the kubeconfig of the environment
+	PhaseKubeconfig Phase = "Kubeconfig written"
+)
+
+// Phases lists the phases in the order Createenvironment runs them
+var Phases = []Phase{PhaseClaims, PhaseVMs, PhaseMachines, PhaseTemplate, PhaseCluster, PhaseKubeconfig}
+
+// EventType is the kind of a progress event
+type EventType string
+
+const (
+	// PhaseStarted is published when a phase starts
+	PhaseStarted EventType = "phase_started"
+	// PhaseProgressed is published when a running phase has new details
+	PhaseProgressed EventType = "phase_progressed"
+	// PhaseFinished is published when a phase completes, with its duration
+	PhaseFinished EventType = "phase_finished"
+	// PhaseFailed is published when a phase fails, with its duration and error
+	PhaseFailed EventType = "phase_failed"
+	// MachineRegistered is published for every machine found in Omni
+	MachineRegistered EventType = "machine_registered"
+	// ConditionChanged is published when the Ready condition of a managed resource changes
+	ConditionChanged EventType = "condition_changed"
+)
+
+// Event is a structured progress event published by Createenvironment
+type Event struct {
+	Type        EventType     `json:"type"`
+	Environment string        `json:"environment"`
+	Phase       Phase         `json:"phase,omitempty"`
+	Time        time.Time     `json:"time"`
+	Duration    time.Duration `json:"duration,omitempty"`
+	Detail      string        `json:"detail,omitempty"`
+	Machine     string        `json:"machine,omitempty"`
+	Resource    string        `json:"resource,omitempty"`
+	Condition   string        `json:"condition,omitempty"`
+	Error       string        `json:"error,omitempty"`
+}
+
+// Observer receives the events published by Createenvironment
+type Observer interface {
+	Notify(event Event)
+}
+
+// ObserverFunc adapts a function to an Observer
+type ObserverFunc func(event Event)
+
+// Notify calls the function
+func (f ObserverFunc) Notify(event Event) {
+	f(event)
+}
+
+// ChannelObserver sends every event on the channel
+func ChannelObserver(events chan<- Event) Observer {
+	return ObserverFunc(func(event Event) { events <- event })
+}
+
+// LogObserver writes every event to the log
+type LogObserver struct{}
+
+// Notify logs the event with its fields
+func (LogObserver) Notify(event Event) {
+	entry := log.WithFields(log.Fields{
+		"environment": event.Environment,
+		"event":       event.Type,
+	})
+	if event.Phase != "" {
+		entry = entry.WithField("phase", event.Phase)
+	}
+	if event.Duration != 0 {
+		entry = entry.WithField("duration", event.Duration.Round(time.Millisecond))
+	}
+	if event.Machine != "" {
+		entry = entry.WithField("machine", event.Machine)
+	}
+	if event.Resource != "" {
+		entry = entry.WithFields(log.Fields{"resource": event.Resource, "condition": event.Condition})
+	}
+	switch event.Type {
+	case PhaseFailed:
+		entry.Error(event.Error)
+	case PhaseProgressed, ConditionChanged:
+		entry.Debug(event.Detail)
+	default:
+		entry.Info(event.Detail)
+	}
+}
+
+// publisher fans the events of one environment out to its observers
+type publisher struct {
+	environment string
+	observers   []Observer
+	mu          sync.Mutex
+	started     map[Phase]time.Time
+}
+
+func newPublisher(environment string, observers []Observer) *publisher {
+	return &publisher{
+		environment: environment,
+		observers:   append([]Observer{LogObserver{}}, observers...),
+		started:     map[Phase]time.Time{},
+	}
+}
+
+// publish stamps the event and sends it to every observer
+func (p *publisher) publish(event Event) {
+	event.Environment = p.environment
+	event.Time = time.Now()
+	for _, observer := range p.observers {
+		observer.Notify(event)
+	}
+}
+
+// elapsed returns the time since the phase started
+func (p *publisher) elapsed(phase Phase) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if started, ok := p.started[phase]; ok {
+		return time.Since(started)
+	}
+	return 0
+}
+
+func (p *publisher) start(phase Phase, detail string) {
+	p.mu.Lock()
+	p.started[phase] = time.Now()
+	p.mu.Unlock()
+	p.publish(Event{Type: PhaseStarted, Phase: phase, Detail: detail})
+}
+
+func (p *publisher) progress(phase Phase, detail string) {
+	p.publish(Event{Type: PhaseProgressed, Phase: phase, Detail: detail})
+}
+
+func (p *publisher) finish(phase Phase, detail string) {
+	p.publish(Event{Type: PhaseFinished, Phase: phase, Detail: detail, Duration: p.elapsed(phase)})
+}
+
+// fail publishes the failure of the phase and returns the error
+func (p *publisher) fail(phase Phase, err error) error {
+	p.publish(Event{Type: PhaseFailed, Phase: phase, Error: err.Error(), Duration: p.elapsed(phase)})
+	return err
+}
+
+func (p *publisher) machine(id, hostname string) {
+	p.publish(Event{Type: MachineRegistered, Phase: PhaseMachines, Machine: id, Detail: hostname})
+}
+
+func (p *publisher) condition(resource, status string) {
+	p.publish(Event{Type: ConditionChanged, Phase: PhaseVMs, Resource: resource, Condition: "Ready=" + status})
+}
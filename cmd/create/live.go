@@ -0,0 +1,51 @@
+package create
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// LiveCluster reads the versions, extra manifests and extra config patches of
+// an existing environment from Omni, so its cluster template can be re-rendered
+// without losing them.
+func LiveCluster(name string) (utils.Environment, error) {
+	environment := utils.Environment{Name: name}
+	cluster, err := utils.GetCluster(name)
+	if err != nil {
+		return environment, fmt.Errorf("environment %s not found in Omni: %w", name, err)
+	}
+	environment.KubernetesVersion = "v" + strings.TrimPrefix(cluster.Spec.KubernetesVersion, "v")
+	environment.TalosVersion = "v" + strings.TrimPrefix(cluster.Spec.TalosVersion, "v")
+
+	patches, err := utils.GetConfigPatches(name)
+	if err != nil {
+		return environment, err
+	}
+	for _, patch := range patches {
+		switch {
+		case patch.Metadata.ID == "100-"+name:
+			// the cluster wide patch of the template, only the manifests can differ
+			inline := struct {
+				Cluster struct {
+					ExtraManifests []string `json:"extraManifests"`
+				} `json:"cluster"`
+			}{}
+			if err := yaml.Unmarshal([]byte(patch.Spec.Data), &inline); err != nil {
+				return environment, fmt.Errorf("parsing patch %s: %w", patch.Metadata.ID, err)
+			}
+			environment.ExtraManifests = inline.Cluster.ExtraManifests
+		case patch.Metadata.ID == "400-"+name:
+			// the gpu machine set patch of the template
+		case patch.Metadata.Labels["omni.sidero.dev/machine-set"] != "" || patch.Metadata.Labels["omni.sidero.dev/cluster-machine"] != "":
+			log.Warnf("Skipping config patch %s, only cluster wide patches are kept", patch.Metadata.ID)
+		default:
+			environment.Patches = append(environment.Patches, utils.ConfigPatch{ID: patch.Metadata.ID, Data: patch.Spec.Data})
+		}
+	}
+	return environment, nil
+}
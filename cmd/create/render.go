@@ -0,0 +1,131 @@
+package create
+
+import (
+	"bytes"
+	"embed"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/validate"
+)
+
+//go:embed templates/*
+var tplFolder embed.FS
+
+// indent indents every line of text by the given number of spaces
+func indent(spaces int, text string) string {
+	prefix := strings.Repeat(" ", spaces)
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quote renders a string as a double quoted YAML scalar, so values such as
+// secrets can contain any character
+func quote(text string) string {
+	return strconv.Quote(text)
+}
+
+// funcs are the functions available in the templates
+var funcs = template.FuncMap{"indent": indent, "quote": quote}
+
+// Declare type pointer to a template
+var claimtemp *template.Template
+var clustertemp *template.Template
+var kubeconfigtemp *template.Template
+
+// Using the init function to make sure the template is only parsed once in the program
+func init() {
+	// template.Must takes the reponse of template.ParseFiles and does error checking
+	claimtemp = template.Must(template.New("claim.tmpl").Funcs(funcs).ParseFS(tplFolder, "templates/claim.tmpl"))
+	clustertemp = template.Must(template.New("cluster.tmpl").Funcs(funcs).ParseFS(tplFolder, "templates/cluster.tmpl"))
+	kubeconfigtemp = template.Must(template.New("kubeconfig.tmpl").Funcs(funcs).ParseFS(tplFolder, "templates/kubeconfig.tmpl"))
+}
+
+// claim is the template data for a single NodeGroupClaim
+type claim struct {
+	ID          string
+	Environment string
+	Role        string
+	Labels      map[string]string
+	Parameters  utils.NodeGroupParameters
+}
+
+// RenderClaims renders the NodeGroupClaims for the environment and validates
+// them against the schema of the XRD
+func RenderClaims(w io.Writer, environment utils.Environment) error {
+	p, err := provider.Get(environment.Provider)
+	if err != nil {
+		return err
+	}
+	claims := []claim{}
+	for _, group := range NodeGroups(environment) {
+		params, err := p.Parameters(group)
+		if err != nil {
+			return err
+		}
+		claims = append(claims, claim{ID: group.ID, Environment: environment.Name, Role: group.Role, Labels: p.Labels(), Parameters: params})
+	}
+	var out bytes.Buffer
+	if err := claimtemp.Execute(&out, claims); err != nil {
+		return err
+	}
+	if err := validate.Claims(out.Bytes()); err != nil {
+		return err
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// RenderCluster renders the Omni cluster template for the machines of each
+// role and validates it. Unset versions and extra manifests get the defaults.
+func RenderCluster(w io.Writer, environment utils.Environment, machines map[string][]string) error {
+	if environment.KubernetesVersion == "" {
+		environment.KubernetesVersion = DefaultKubernetesVersion
+	}
+	if environment.TalosVersion == "" {
+		environment.TalosVersion = DefaultTalosVersion
+	}
+	if len(environment.ExtraManifests) == 0 {
+		environment.ExtraManifests = DefaultExtraManifests
+	}
+	environment.ControlPlane = machineList(machines["ctlr"])
+	environment.Workers = machineList(machines["worker"])
+	environment.Gpus = machineList(machines["gpu"])
+	log.Debug("Control Plane: ", environment.ControlPlane)
+	log.Debug("Workers: ", environment.Workers)
+	log.Debug("Gpus: ", environment.Gpus)
+	var out bytes.Buffer
+	if err := clustertemp.Execute(&out, environment); err != nil {
+		return err
+	}
+	if err := validate.ClusterTemplate(out.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// RenderKubeconfig renders the kubeconfig that reaches the environment through tailscale
+func RenderKubeconfig(w io.Writer, environment utils.Environment) error {
+	return kubeconfigtemp.Execute(w, environment)
+}
+
+// machineList renders machine IDs as a YAML list for the cluster template
+func machineList(ids []string) string {
+	lines := []string{}
+	for _, id := range ids {
+		lines = append(lines, "  - "+id)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,230 @@
+package create
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// renderCase is the input of one set of golden files
+type renderCase struct {
+	name        string
+	environment utils.Environment
+	machines    map[string][]string
+}
+
+var renderCases = []renderCase{
+	{
+		name:        "cpu-only",
+		environment: environment("dev-1a2b", "gcp", false),
+		machines:    map[string][]string{"ctlr": {"ctlr-1"}, "worker": {"worker-1"}},
+	},
+	{
+		name:        "gpu",
+		environment: environment("dev-1a2b", "gcp", true),
+		machines:    map[string][]string{"ctlr": {"ctlr-1"}, "worker": {"worker-1"}, "gpu": {"gpu-1"}},
+	},
+	{
+		name: "multi-worker",
+		environment: func() utils.Environment {
+			env := environment("big-5e6f", "gcp", false)
+			env.NodeGroups = []utils.NodeGroup{
+				{Role: "ctlr", Replicas: 3, Size: utils.SizeSmall, DiskSize: 50},
+				{Role: "worker", Replicas: 2, Size: utils.SizeMedium, DiskSize: 100},
+				{ID: "big-5e6f-highmem-group", Role: "worker", Replicas: 2, Size: utils.SizeLarge, DiskSize: 200},
+			}
+			return env
+		}(),
+		machines: map[string][]string{"ctlr": {"ctlr-1", "ctlr-2", "ctlr-3"}, "worker": {"worker-1", "worker-2", "worker-3", "worker-4"}},
+	},
+	{
+		name: "special-characters",
+		environment: func() utils.Environment {
+			env := environment("odd-7a8b", "azure", false)
+			env.TailScaleClientID = `id: with "quotes" # and a hash`
+			env.TailScaleClientSecret = `tskey-'single' \backslash {{ braces }}`
+			env.GitHubToken = `ghp_&*!|>%@`
+			env.ExtraManifests = []string{"https://example.com/manifest.yaml?ref=main&path=a b#fragment"}
+			env.Patches = []utils.ConfigPatch{{ID: "500-odd-7a8b", Data: "machine:\n  sysctls:\n    vm.max_map_count: \"262144\"\n"}}
+			return env
+		}(),
+		machines: map[string][]string{"ctlr": {"ctlr-1"}, "worker": {"worker-1"}},
+	},
+}
+
+// environment returns an environment with fixed secrets and versions
+func environment(name, cloud string, gpu bool) utils.Environment {
+	return utils.Environment{
+		Name:                  name,
+		Endpoint:              "https://" + name + "-ts.tail5abf9.ts.net",
+		Provider:              cloud,
+		Gpu:                   gpu,
+		TailScaleClientID:     "client-id",
+		TailScaleClientSecret: "client-secret",
+		GitHubToken:           "github-token",
+	}
+}
+
+// fixedProviderEnv makes the provider parameters independent of the environment of the test run
+func fixedProviderEnv(t *testing.T) {
+	t.Helper()
+	for _, key := range []string{"GCP_PROJECT", "GCP_ZONE", "GCP_DISK_TYPE", "GCP_SERVICE_ACCOUNT", "GCP_IMAGE_CTLR", "GCP_IMAGE_WORKER", "GCP_IMAGE_GPU", "AZURE_LOCATION", "AZURE_RESOURCE_GROUP", "AZURE_STORAGE_TYPE"} {
+		t.Setenv(key, "")
+	}
+	t.Setenv("AZURE_IMAGE_WORKER", "/images/worker")
+	t.Setenv("AZURE_IMAGE_CTLR", "/images/ctlr")
+	t.Setenv("AZURE_IMAGE_GPU", "/images/gpu")
+	t.Setenv("AZURE_SUBNET_ID", "/subnets/default")
+	t.Setenv("AZURE_SSH_PUBLIC_KEY", `ssh-ed25519 AAAA "talos"@tanuu \n`)
+}
+
+func TestRenderGolden(t *testing.T) {
+	fixedProviderEnv(t)
+	for _, tc := range renderCases {
+		renders := map[string]func(w io.Writer) error{
+			"claims": func(w io.Writer) error { return RenderClaims(w, tc.environment) },
+			"cluster": func(w io.Writer) error {
+				return RenderCluster(w, tc.environment, tc.machines)
+			},
+			"kubeconfig": func(w io.Writer) error { return RenderKubeconfig(w, tc.environment) },
+		}
+		for kind, render := range renders {
+			t.Run(tc.name+"/"+kind, func(t *testing.T) {
+				var out bytes.Buffer
+				if err := render(&out); err != nil {
+					t.Fatalf("render: %v", err)
+				}
+				assertRoundTrip(t, out.Bytes())
+				golden(t, filepath.Join("testdata", tc.name+"."+kind+".yaml"), out.Bytes())
+			})
+		}
+	}
+}
+
+func TestRenderClusterSpecialCharacters(t *testing.T) {
+	tc := renderCases[3]
+	var out bytes.Buffer
+	if err := RenderCluster(&out, tc.environment, tc.machines); err != nil {
+		t.Fatalf("RenderCluster: %v", err)
+	}
+	docs := parseDocuments(t, out.Bytes())
+	cluster := struct {
+		Patches []struct {
+			Inline struct {
+				Cluster struct {
+					ExtraManifests       []string          `json:"extraManifests"`
+					ExtraManifestHeaders map[string]string `json:"extraManifestHeaders"`
+					InlineManifests      []struct {
+						Name     string `json:"name"`
+						Contents string `json:"contents"`
+					} `json:"inlineManifests"`
+				} `json:"cluster"`
+			} `json:"inline"`
+		} `json:"patches"`
+	}{}
+	if err := sigsyaml.Unmarshal(docs[0], &cluster); err != nil {
+		t.Fatalf("parsing the Cluster document: %v", err)
+	}
+	patch := cluster.Patches[0].Inline.Cluster
+	if !reflect.DeepEqual(patch.ExtraManifests, tc.environment.ExtraManifests) {
+		t.Errorf("extraManifests = %q, want %q", patch.ExtraManifests, tc.environment.ExtraManifests)
+	}
+	if got := patch.ExtraManifestHeaders["Authorization"]; got != "Bearer "+tc.environment.GitHubToken {
+		t.Errorf("Authorization = %q", got)
+	}
+	for _, manifest := range patch.InlineManifests {
+		if manifest.Name != "secret-tailscale" {
+			continue
+		}
+		secret := struct {
+			StringData map[string]string `json:"stringData"`
+		}{}
+		if err := sigsyaml.Unmarshal([]byte(manifest.Contents), &secret); err != nil {
+			t.Fatalf("parsing the tailscale secret: %v\n%s", err, manifest.Contents)
+		}
+		if secret.StringData["client_id"] != tc.environment.TailScaleClientID || secret.StringData["client_secret"] != tc.environment.TailScaleClientSecret {
+			t.Errorf("tailscale secret = %q", secret.StringData)
+		}
+		return
+	}
+	t.Error("the cluster template has no tailscale secret")
+}
+
+// parseDocuments splits a multi-document YAML stream, skipping empty documents
+func parseDocuments(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	docs := [][]byte{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return docs
+		}
+		if err != nil {
+			t.Fatalf("reading YAML: %v", err)
+		}
+		if len(bytes.TrimSpace(doc)) > 0 {
+			docs = append(docs, doc)
+		}
+	}
+}
+
+// assertRoundTrip checks that every document parses and survives being marshalled again
+func assertRoundTrip(t *testing.T, data []byte) {
+	t.Helper()
+	docs := parseDocuments(t, data)
+	if len(docs) == 0 {
+		t.Fatal("no YAML documents rendered")
+	}
+	for i, doc := range docs {
+		var parsed interface{}
+		if err := sigsyaml.Unmarshal(doc, &parsed); err != nil {
+			t.Fatalf("document %d is not valid YAML: %v\n%s", i, err, doc)
+		}
+		marshalled, err := sigsyaml.Marshal(parsed)
+		if err != nil {
+			t.Fatalf("document %d: marshal: %v", i, err)
+		}
+		var again interface{}
+		if err := sigsyaml.Unmarshal(marshalled, &again); err != nil {
+			t.Fatalf("document %d: unmarshal again: %v", i, err)
+		}
+		if !reflect.DeepEqual(parsed, again) {
+			t.Errorf("document %d changed in the round trip:\n%v\n%v", i, parsed, again)
+		}
+	}
+}
+
+// golden compares the output to a golden file, or updates it with -update
+func golden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run the tests with -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the rendered output, run the tests with -update if the change is intended:\n%s", path, got)
+	}
+}
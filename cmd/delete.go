@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/destroy"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/notify"
+)
+
+var forceDelete bool
+var deleteTimeout time.Duration
+
+// deleteCmd deletes an environment
+var deleteCmd = &cobra.Command{
+	Use:   "delete <environment>",
+	Short: "delete an environment",
+	Long: `Delete the Omni cluster, the machines and the node group claims of an environment.
+The resources to delete are listed and need confirmation, unless --yes is set.
+Environments with the protected=true label on a claim or the Omni cluster are only deleted with --force.
+Delete waits until the Omni cluster, the machine links, the claims and their VM instances are gone,
+and exits with an error if anything remains after the timeout.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		plan, err := destroy.PlanDeletion(args[0])
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		plan.Print(os.Stderr)
+		if len(plan.Protected) > 0 && !forceDelete {
+			log.Fatalf("Environment %s is protected, use --force to delete it", plan.Name)
+		}
+		if !assumeYes && !confirm("Delete these resources?") {
+			log.Info("Aborted")
+			return
+		}
+		start := time.Now()
+		result, err := destroy.Destroyenvironment(plan, forceDelete, deleteTimeout)
+		if err != nil {
+			log.Errorf("Error deleting environment: %v", err)
+			notify.Publish(notify.NewEvent(notify.DeleteFailed, plan.Name, start, err))
+		} else {
+			notify.Publish(notify.NewEvent(notify.Deleted, plan.Name, start, nil))
+		}
+		printResult(format, result, func(w io.Writer) {
+			result.Print(w)
+			if err == nil {
+				fmt.Fprintf(w, "Environment %s deleted: %d machines, %d claims.\n", result.Name, len(result.Machines), len(result.Claims))
+			}
+		})
+		if err != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	deleteCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+	deleteCmd.Flags().BoolVar(&forceDelete, "force", false, "Delete the environment even if it is protected")
+	deleteCmd.Flags().DurationVar(&deleteTimeout, "timeout", destroy.DefaultTimeout, "How long to wait for each step of the deletion to complete")
+}
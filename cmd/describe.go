@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// environmentDescription is the output of the describe command
+type environmentDescription struct {
+	Name       string                 `json:"name"`
+	Endpoint   string                 `json:"endpoint"`
+	Kubeconfig string                 `json:"kubeconfig,omitempty"`
+	Phase      string                 `json:"phase,omitempty"`
+	Ready      bool                   `json:"ready"`
+	NodeGroups []nodeGroupDescription `json:"nodeGroups"`
+	Machines   map[string][]string    `json:"machines"`
+}
+
+// nodeGroupDescription describes a single node group claim
+type nodeGroupDescription struct {
+	ID          string `json:"id"`
+	MachineType string `json:"machineType"`
+	Replicas    int    `json:"replicas"`
+	DiskSize    int    `json:"diskSize"`
+}
+
+// describeCmd shows the details of an environment
+var describeCmd = &cobra.Command{
+	Use:   "describe <environment>",
+	Short: "describe an environment",
+	Long:  `Show the node groups, machines and cluster status of an environment.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		name := args[0]
+		description := environmentDescription{Name: name, Endpoint: utils.Endpoint(name), NodeGroups: []nodeGroupDescription{}}
+		if state.Exists(name, state.KubeconfigFile) {
+			description.Kubeconfig = state.Path(name, state.KubeconfigFile)
+		}
+		claims, err := utils.ListClaims()
+		if err != nil {
+			log.Fatalf("Error listing claims: %v", err)
+		}
+		owned := []utils.NodeGroupClaim{}
+		for _, claim := range claims {
+			if !claim.OwnedBy(name) {
+				continue
+			}
+			owned = append(owned, claim)
+			params := claim.Spec.Parameters
+			description.NodeGroups = append(description.NodeGroups, nodeGroupDescription{
+				ID:          claim.Spec.ID,
+				MachineType: params.MachineType,
+				Replicas:    params.Replicas,
+				DiskSize:    params.Size,
+			})
+		}
+		status, err := utils.GetClusterStatus(name)
+		if err != nil {
+			log.Warnf("No cluster status for %s: %v", name, err)
+		} else {
+			description.Phase = status.Spec.Phase
+			description.Ready = status.Spec.Ready
+		}
+		// machines belong to the environment by the hostname of its claims,
+		// not by containing its name
+		nodes, err := utils.ListMachines()
+		if err != nil {
+			log.Fatalf("Error finding machines: %v", err)
+		}
+		description.Machines = utils.MachinesByGroup(utils.ClaimGroups(owned), nodes)
+		if len(description.NodeGroups) == 0 && status.Metadata.ID == "" {
+			log.Fatalf("Environment %s not found", name)
+		}
+
+		printResult(format, description, func(w io.Writer) {
+			fmt.Fprintf(w, "Name:       %s\n", description.Name)
+			fmt.Fprintf(w, "Endpoint:   %s\n", description.Endpoint)
+			fmt.Fprintf(w, "Kubeconfig: %s\n", description.Kubeconfig)
+			fmt.Fprintf(w, "Phase:      %s (ready: %t)\n", description.Phase, description.Ready)
+			fmt.Fprintln(w, "Node groups:")
+			for _, group := range description.NodeGroups {
+				fmt.Fprintf(w, "  %s: %d x %s, %dGB\n", group.ID, group.Replicas, group.MachineType, group.DiskSize)
+			}
+			fmt.Fprintln(w, "Machines:")
+			roles := []string{}
+			for role := range description.Machines {
+				roles = append(roles, role)
+			}
+			sort.Strings(roles)
+			for _, role := range roles {
+				fmt.Fprintf(w, "  %s: %s\n", role, strings.Join(description.Machines[role], ", "))
+			}
+		})
+	},
+}
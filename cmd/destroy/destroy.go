@@ -0,0 +1,255 @@
+package destroy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// ErrProtected is returned when deleting a protected environment without force
+var ErrProtected = errors.New("environment is protected")
+
+// DefaultTimeout is how long each step of the deletion may take to complete
+const DefaultTimeout = 15 * time.Minute
+
+// Step is the outcome of one step of the deletion
+type Step struct {
+	Name      string   `json:"name"`
+	OK        bool     `json:"ok"`
+	Error     string   `json:"error,omitempty"`
+	Remaining []string `json:"remaining,omitempty"`
+}
+
+// Result describes a deleted environment
+type Result struct {
+	Name     string   `json:"name"`
+	Machines []string `json:"machines"`
+	Claims   []string `json:"claims"`
+	Steps    []Step   `json:"steps"`
+}
+
+// Plan lists the resources that deleting an environment removes
+type Plan struct {
+	Name      string   `json:"name"`
+	Cluster   bool     `json:"cluster"`
+	Machines  []string `json:"machines"`
+	Claims    []string `json:"claims"`
+	Protected []string `json:"protected"`
+}
+
+// Empty reports whether the environment has no resources left
+func (p Plan) Empty() bool {
+	return !p.Cluster && len(p.Machines) == 0 && len(p.Claims) == 0
+}
+
+// Print writes the resources of the plan in a human readable form
+func (p Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Deleting environment %s removes:\n", p.Name)
+	if p.Cluster {
+		fmt.Fprintf(w, "  Omni cluster      %s\n", p.Name)
+	}
+	for _, machine := range p.Machines {
+		fmt.Fprintf(w, "  Omni machine link %s\n", machine)
+	}
+	for _, claim := range p.Claims {
+		fmt.Fprintf(w, "  NodeGroupClaim    %s\n", claim)
+	}
+	for _, resource := range p.Protected {
+		fmt.Fprintf(w, "Protected by %s=true: %s\n", utils.ProtectedLabel, resource)
+	}
+}
+
+// PlanDeletion finds the resources of an environment. Claims must have the
+// name prefix and the environment label of the environment, and machines must
+// be part of its Omni cluster or have the hostname of one of its claims.
+func PlanDeletion(name string) (Plan, error) {
+	plan := Plan{Name: name, Machines: []string{}, Claims: []string{}, Protected: []string{}}
+
+	clusters, err := utils.ListClusters()
+	if err != nil {
+		return plan, fmt.Errorf("listing Omni clusters: %w", err)
+	}
+	plan.Cluster = slices.Contains(clusters, name)
+
+	claims, err := utils.ListClaims()
+	if err != nil {
+		return plan, fmt.Errorf("listing nodegroupclaims: %w", err)
+	}
+	for _, claim := range claims {
+		if !claim.OwnedBy(name) {
+			continue
+		}
+		plan.Claims = append(plan.Claims, claim.Metadata.Name)
+		if claim.Protected() {
+			plan.Protected = append(plan.Protected, "nodegroupclaim "+claim.Metadata.Name)
+		}
+	}
+
+	seen := map[string]bool{}
+	addMachine := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			plan.Machines = append(plan.Machines, id)
+		}
+	}
+	if plan.Cluster {
+		cluster, err := utils.GetCluster(name)
+		if err != nil {
+			return plan, fmt.Errorf("getting Omni cluster %s: %w", name, err)
+		}
+		if cluster.Metadata.Labels[utils.ProtectedLabel] == "true" {
+			plan.Protected = append(plan.Protected, "cluster "+name)
+		}
+		members, err := utils.FindClusterMachines(name)
+		if err != nil {
+			return plan, fmt.Errorf("listing the machines of %s: %w", name, err)
+		}
+		for _, machine := range members {
+			addMachine(machine.Metadata.ID)
+		}
+	}
+	if len(plan.Claims) > 0 {
+		groups := []utils.GroupInstances{}
+		for _, claim := range plan.Claims {
+			groups = append(groups, utils.GroupInstances{Claim: claim})
+		}
+		nodes, err := utils.FindGroupMachines(groups)
+		if err != nil {
+			return plan, fmt.Errorf("listing machines: %w", err)
+		}
+		for _, node := range nodes {
+			addMachine(node.Metadata.ID)
+		}
+	}
+
+	if plan.Empty() {
+		return plan, fmt.Errorf("environment %s not found", name)
+	}
+	return plan, nil
+}
+
+// Destroyenvironment deletes the resources of the plan and waits up to the
+// timeout for each step to complete. Protected environments are only deleted
+// with force. The local state is kept when anything remains.
+func Destroyenvironment(plan Plan, force bool, timeout time.Duration) (Result, error) {
+	name := plan.Name
+	result := Result{Name: name, Machines: []string{}, Claims: []string{}, Steps: []Step{}}
+	if len(plan.Protected) > 0 && !force {
+		return result, fmt.Errorf("%w: %s has the %s=true label on %s, use --force to delete it", ErrProtected, name, utils.ProtectedLabel, strings.Join(plan.Protected, ", "))
+	}
+	log.Debug("Deleting environment with name: ", name)
+
+	if plan.Cluster {
+		err := utils.DeleteOmniCluster(name)
+		if err == nil {
+			err = utils.WaitForClusterDeleted(name, timeout)
+		}
+		remaining := []string{}
+		if err != nil {
+			remaining = append(remaining, name)
+		}
+		result.record("delete Omni cluster", remaining, err)
+	}
+
+	if len(plan.Machines) > 0 {
+		deleted, failed := []string{}, []string{}
+		errs := []error{}
+		for _, machine := range plan.Machines {
+			log.Debug("Deleting machine link: ", machine)
+			if err := utils.DeleteOmniMachine(machine); err != nil {
+				failed = append(failed, machine)
+				errs = append(errs, fmt.Errorf("deleting link %s: %w", machine, err))
+				continue
+			}
+			deleted = append(deleted, machine)
+		}
+		remaining, err := utils.WaitForLinksDeleted(deleted, timeout)
+		result.Machines = without(deleted, remaining)
+		result.record("remove machine links", append(failed, remaining...), errors.Join(append(errs, err)...))
+	}
+
+	if len(plan.Claims) > 0 {
+		deleted, deleteErr := utils.DeleteNodes(plan.Claims)
+		remaining, err := utils.WaitForClaimsDeleted(deleted, timeout)
+		for _, claim := range deleted {
+			if !slices.Contains(remaining, "nodegroupclaim/"+claim) {
+				result.Claims = append(result.Claims, claim)
+			}
+		}
+		for _, claim := range without(plan.Claims, deleted) {
+			remaining = append(remaining, "nodegroupclaim/"+claim)
+		}
+		result.record("delete nodegroupclaims and VM instances", remaining, errors.Join(deleteErr, err))
+	}
+
+	err := result.Err()
+	if err != nil {
+		log.Errorf("Deleting %s did not complete: %v", name, err)
+		return result, err
+	}
+	if err := state.RemoveEnvironment(name); err != nil {
+		log.Warnf("Failed to remove state of %s: %v", name, err)
+	}
+	log.Debug("Environment Deletion Completed.")
+	return result, nil
+}
+
+// record adds the outcome of a step to the result
+func (r *Result) record(name string, remaining []string, err error) {
+	step := Step{Name: name, OK: err == nil && len(remaining) == 0, Remaining: remaining}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	log.Debugf("Step %s: ok=%t remaining=%v", name, step.OK, remaining)
+	r.Steps = append(r.Steps, step)
+}
+
+// Err returns an error that lists the steps that did not complete
+func (r Result) Err() error {
+	failed := []string{}
+	for _, step := range r.Steps {
+		if !step.OK {
+			failed = append(failed, step.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s not deleted completely: %s failed", r.Name, strings.Join(failed, ", "))
+}
+
+// Print writes the outcome of each step in a human readable form
+func (r Result) Print(w io.Writer) {
+	for _, step := range r.Steps {
+		status := "ok  "
+		if !step.OK {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "[%s] %s\n", status, step.Name)
+		if step.Error != "" {
+			fmt.Fprintf(w, "       error: %s\n", step.Error)
+		}
+		if len(step.Remaining) > 0 {
+			fmt.Fprintf(w, "       remaining: %s\n", strings.Join(step.Remaining, ", "))
+		}
+	}
+}
+
+// without returns the items that are not in exclude
+func without(items, exclude []string) []string {
+	kept := []string{}
+	for _, item := range items {
+		if !slices.Contains(exclude, item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
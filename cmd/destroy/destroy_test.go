@@ -0,0 +1,142 @@
+package destroy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+// claim is a NodeGroupClaim in the `kubectl get nodegroupclaims -o json` output
+func claim(name, environment string, protected bool) string {
+	labels := map[string]string{}
+	if environment != "" {
+		labels["tanuu.dev/environment"] = environment
+	}
+	if protected {
+		labels["protected"] = "true"
+	}
+	return fmt.Sprintf(`{"metadata":{"name":%q,"labels":%s}}`, name, toJSON(labels))
+}
+
+func toJSON(labels map[string]string) string {
+	pairs := []string{}
+	for key, value := range labels {
+		pairs = append(pairs, fmt.Sprintf("%q:%q", key, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func machine(id, hostname string) string {
+	return fmt.Sprintf(`{"metadata":{"id":%q},"spec":{"connected":true,"platformmetadata":{"hostname":%q}}}`, id, hostname)
+}
+
+func TestPlanDeletion(t *testing.T) {
+	claims := `{"items":[` + strings.Join([]string{
+		claim("dev-1a2b-ctlr-group", "dev-1a2b", false),
+		claim("dev-1a2b-worker-group", "", false),
+		claim("dev-1a2b9-ctlr-group", "dev-1a2b9", false),
+		claim("dev-1a2b9-worker-group", "", false),
+	}, ",") + `]}`
+	machines := "[" + strings.Join([]string{
+		machine("m1", "dev-1a2b-ctlr-group-x7k2p"),
+		machine("m2", "dev-1a2b-worker-group-q9z3d"),
+		machine("m3", "dev-1a2b9-worker-group-b4n8c"),
+	}, ",") + "]"
+	run := map[string]string{
+		"omnictl get clusters -o json":                                           `[{"metadata":{"id":"dev-1a2b9"}}]`,
+		"kubectl get nodegroupclaims -o json":                                    claims,
+		"omnictl get machinestatus -o json":                                      machines,
+		"omnictl get cluster dev-1a2b9 -o json":                                  `{"metadata":{"id":"dev-1a2b9","labels":{"protected":"true"}}}`,
+		"omnictl get machinestatus -o json -l omni.sidero.dev/cluster=dev-1a2b9": "[" + machine("m3", "dev-1a2b9-worker-group-b4n8c") + "]",
+	}
+	t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, utilstest.Outputs(run))))
+
+	plan, err := PlanDeletion("dev-1a2b")
+	if err != nil {
+		t.Fatalf("PlanDeletion() error = %v", err)
+	}
+	want := Plan{
+		Name:      "dev-1a2b",
+		Machines:  []string{"m1", "m2"},
+		Claims:    []string{"dev-1a2b-ctlr-group", "dev-1a2b-worker-group"},
+		Protected: []string{},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Errorf("PlanDeletion() = %+v, want %+v", plan, want)
+	}
+
+	plan, err = PlanDeletion("dev-1a2b9")
+	if err != nil {
+		t.Fatalf("PlanDeletion() error = %v", err)
+	}
+	if !plan.Cluster || !reflect.DeepEqual(plan.Machines, []string{"m3"}) || !reflect.DeepEqual(plan.Protected, []string{"cluster dev-1a2b9"}) {
+		t.Errorf("PlanDeletion() = %+v, want the protected cluster with machine m3", plan)
+	}
+
+	if _, err := PlanDeletion("dev"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("PlanDeletion(dev) error = %v, want not found", err)
+	}
+}
+
+func TestDestroyProtected(t *testing.T) {
+	t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, nil)))
+	plan := Plan{Name: "dev-1a2b", Cluster: true, Claims: []string{"dev-1a2b-ctlr-group"}, Protected: []string{"nodegroupclaim dev-1a2b-ctlr-group"}}
+	result, err := Destroyenvironment(plan, false, time.Minute)
+	if !errors.Is(err, ErrProtected) {
+		t.Fatalf("Destroyenvironment() error = %v, want ErrProtected", err)
+	}
+	if len(result.Claims) != 0 || len(result.Machines) != 0 {
+		t.Errorf("Destroyenvironment() = %+v, want nothing deleted", result)
+	}
+}
+
+func TestDestroyenvironmentVerifies(t *testing.T) {
+	t.Setenv("TANUU_STATE_DIR", t.TempDir())
+	plan := Plan{Name: "dev-1a2b", Cluster: true, Machines: []string{"m1"}, Claims: []string{"dev-1a2b-ctlr-group"}}
+	deleted := map[string]string{
+		"omnictl cluster delete dev-1a2b":                   "",
+		"omnictl get clusters -o json":                      "[]",
+		"omnictl delete link m1":                            "",
+		"omnictl get links -o json":                         "",
+		"kubectl delete nodegroupclaim dev-1a2b-ctlr-group": "",
+		"kubectl get nodegroupclaims -o json":               `{"items":[]}`,
+		"kubectl get managed -o json -l crossplane.io/claim-name in (dev-1a2b-ctlr-group)": `{"items":[]}`,
+	}
+
+	t.Run("everything deleted", func(t *testing.T) {
+		t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, utilstest.Outputs(deleted))))
+		result, err := Destroyenvironment(plan, false, time.Minute)
+		if err != nil {
+			t.Fatalf("Destroyenvironment() error = %v", err)
+		}
+		if len(result.Steps) != 3 || !reflect.DeepEqual(result.Machines, []string{"m1"}) || !reflect.DeepEqual(result.Claims, plan.Claims) {
+			t.Errorf("Destroyenvironment() = %+v", result)
+		}
+	})
+
+	t.Run("link and instance remain", func(t *testing.T) {
+		remaining := map[string]string{}
+		for command, output := range deleted {
+			remaining[command] = output
+		}
+		remaining["omnictl get links -o json"] = `{"metadata":{"id":"m1"}}`
+		remaining["kubectl get managed -o json -l crossplane.io/claim-name in (dev-1a2b-ctlr-group)"] = `{"items":[{"apiVersion":"compute.gcp.upbound.io/v1beta1","kind":"Instance","metadata":{"name":"dev-1a2b-ctlr-group-x7k2p"}}]}`
+		t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, utilstest.Outputs(remaining))))
+		result, err := Destroyenvironment(plan, false, 0)
+		if err == nil || !strings.Contains(err.Error(), "remove machine links, delete nodegroupclaims and VM instances failed") {
+			t.Fatalf("Destroyenvironment() error = %v, want the failed steps", err)
+		}
+		if !result.Steps[0].OK || result.Steps[1].OK || !reflect.DeepEqual(result.Steps[2].Remaining, []string{"instance.compute.gcp.upbound.io/dev-1a2b-ctlr-group-x7k2p"}) {
+			t.Errorf("Destroyenvironment() steps = %+v", result.Steps)
+		}
+		if len(result.Machines) != 0 {
+			t.Errorf("Destroyenvironment() machines = %v, want none verified", result.Machines)
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/doctor"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+)
+
+var doctorProvider string
+
+// doctorCmd checks everything create needs
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "check the setup for creating environments",
+	Long:  `Check the tools, the ops cluster, the Crossplane resources, the Omni credentials and the secrets create needs, with a hint for each failed check.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		if _, err := provider.Get(doctorProvider); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		results := doctor.Run(doctorProvider)
+		printResult(format, results, func(w io.Writer) {
+			for _, result := range results {
+				status := "ok  "
+				if !result.OK {
+					status = "FAIL"
+				}
+				fmt.Fprintf(w, "[%s] %s", status, result.Check)
+				if result.Detail != "" {
+					fmt.Fprintf(w, ": %s", result.Detail)
+				}
+				fmt.Fprintln(w)
+				if !result.OK && result.Hint != "" {
+					fmt.Fprintf(w, "       hint: %s\n", result.Hint)
+				}
+			}
+		})
+		if !doctor.Healthy(results) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorProvider, "provider", "p", provider.Default, "Cloud provider to check ("+strings.Join(provider.Names(), "|")+")")
+}
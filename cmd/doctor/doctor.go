@@ -0,0 +1,262 @@
+package doctor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Check  string `json:"check"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// Functions are the Crossplane functions the compositions run
+var Functions = []string{"function-go-templating", "function-auto-ready"}
+
+// providerConfigs are the ProviderConfig resources of each provider
+var providerConfigs = map[string]string{
+	"gcp":   "providerconfigs.gcp.upbound.io",
+	"aws":   "providerconfigs.aws.upbound.io",
+	"azure": "providerconfigs.azure.upbound.io",
+}
+
+// run runs a command and returns its stdout, errors include stderr
+func run(name string, args ...string) ([]byte, error) {
+	return utils.RunCommand(30*time.Second, name, args...)
+}
+
+// condition is the status condition of a Kubernetes resource
+type condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// conditions gets a resource from the ops cluster and returns its conditions by type
+func conditions(kind, name string) (map[string]condition, error) {
+	output, err := run("kubectl", "get", kind, name, "-o", "json")
+	if err != nil {
+		return nil, err
+	}
+	resource := struct {
+		Status struct {
+			Conditions []condition `json:"conditions"`
+		} `json:"status"`
+	}{}
+	if err := json.Unmarshal(output, &resource); err != nil {
+		return nil, err
+	}
+	byType := map[string]condition{}
+	for _, c := range resource.Status.Conditions {
+		byType[c.Type] = c
+	}
+	return byType, nil
+}
+
+// requireConditions reports the first of the condition types that is not True
+func requireConditions(byType map[string]condition, types ...string) error {
+	for _, t := range types {
+		c, ok := byType[t]
+		if !ok {
+			return fmt.Errorf("no %s condition", t)
+		}
+		if c.Status != "True" {
+			return fmt.Errorf("%s is %s: %s %s", t, c.Status, c.Reason, c.Message)
+		}
+	}
+	return nil
+}
+
+// Run runs all checks for creating environments with the given provider
+func Run(cloud string) []Result {
+	results := []Result{checkKubectl(), checkOmnictl(), checkOpsCluster()}
+	if !results[0].OK || !results[2].OK {
+		// the remaining cluster checks would all fail for the same reason
+		results = append(results, Result{Check: "crossplane resources", Detail: "skipped, the ops cluster is not reachable", Hint: "fix the checks above first"})
+	} else {
+		results = append(results, checkXRD(), checkComposition(cloud))
+		for _, function := range Functions {
+			results = append(results, checkFunction(function))
+		}
+		results = append(results, checkProviderConfig(cloud))
+	}
+	results = append(results, checkOmni(), checkSecrets())
+	return results
+}
+
+// Healthy reports whether all checks passed
+func Healthy(results []Result) bool {
+	for _, result := range results {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+func checkKubectl() Result {
+	result := Result{Check: "kubectl"}
+	output, err := run("kubectl", "version", "--client", "-o", "json")
+	if err != nil {
+		result.Detail = err.Error()
+		result.Hint = "install kubectl, e.g. with `devbox shell`"
+		return result
+	}
+	version := struct {
+		ClientVersion struct {
+			GitVersion string `json:"gitVersion"`
+		} `json:"clientVersion"`
+	}{}
+	json.Unmarshal(output, &version)
+	result.OK = true
+	result.Detail = version.ClientVersion.GitVersion
+	return result
+}
+
+func checkOmnictl() Result {
+	result := Result{Check: "omnictl"}
+	output, err := run("omnictl", "--version")
+	if err != nil {
+		result.Detail = err.Error()
+		result.Hint = "install omnictl, e.g. with `devbox shell`"
+		return result
+	}
+	result.OK = true
+	result.Detail = strings.TrimSpace(string(output))
+	return result
+}
+
+func checkOpsCluster() Result {
+	result := Result{Check: "ops cluster"}
+	kubeconfig := os.Getenv("KUBECONFIG")
+	if kubeconfig == "" {
+		result.Detail = "KUBECONFIG is not set"
+		result.Hint = "export KUBECONFIG=kubeconfig with the kubeconfig of the ops cluster"
+		return result
+	}
+	if _, err := run("kubectl", "get", "--raw", "/readyz"); err != nil {
+		result.Detail = err.Error()
+		result.Hint = "check that " + kubeconfig + " points at the ops cluster and its credentials are valid"
+		return result
+	}
+	result.OK = true
+	result.Detail = kubeconfig
+	return result
+}
+
+func checkXRD() Result {
+	result := Result{Check: "XRD nodegroups.tanuu.dev"}
+	byType, err := conditions("compositeresourcedefinitions", "nodegroups.tanuu.dev")
+	if err == nil {
+		err = requireConditions(byType, "Established")
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		result.Hint = "run `bootstrap` to install it"
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+func checkComposition(cloud string) Result {
+	p, err := provider.Get(cloud)
+	if err != nil {
+		return Result{Check: "composition", Detail: err.Error()}
+	}
+	result := Result{Check: "composition for " + p.Name()}
+	selector := []string{}
+	for key, value := range p.Labels() {
+		selector = append(selector, key+"="+value)
+	}
+	sort.Strings(selector)
+	output, err := run("kubectl", "get", "compositions", "-l", strings.Join(selector, ","), "-o", "name")
+	if err == nil && strings.TrimSpace(string(output)) == "" {
+		err = fmt.Errorf("no composition with labels %s", strings.Join(selector, ","))
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		result.Hint = "run `bootstrap --provider " + p.Name() + "` to install it"
+		return result
+	}
+	result.OK = true
+	result.Detail = strings.TrimPrefix(strings.TrimSpace(string(output)), "composition.apiextensions.crossplane.io/")
+	return result
+}
+
+func checkFunction(name string) Result {
+	result := Result{Check: "function " + name}
+	byType, err := conditions("functions.pkg.crossplane.io", name)
+	if err == nil {
+		err = requireConditions(byType, "Installed", "Healthy")
+	}
+	if err != nil {
+		result.Detail = err.Error()
+		result.Hint = "run `bootstrap` to install the functions"
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+func checkProviderConfig(cloud string) Result {
+	if cloud == "" {
+		cloud = provider.Default
+	}
+	kind := providerConfigs[cloud]
+	result := Result{Check: "ProviderConfig for " + cloud}
+	if _, err := run("kubectl", "get", kind, "default"); err != nil {
+		result.Detail = err.Error()
+		result.Hint = "run `bootstrap --provider " + cloud + "` to install it"
+		return result
+	}
+	result.OK = true
+	return result
+}
+
+func checkOmni() Result {
+	result := Result{Check: "omni credentials"}
+	missing := []string{}
+	for _, key := range []string{"OMNI_ENDPOINT", "OMNI_SERVICE_ACCOUNT_KEY"} {
+		if os.Getenv(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		result.Detail = strings.Join(missing, ", ") + " not set"
+		result.Hint = "fetch the Omni secrets with teller, see .teller-example.yml"
+		return result
+	}
+	if _, err := run("omnictl", "get", "clusters"); err != nil {
+		result.Detail = err.Error()
+		result.Hint = "check OMNI_ENDPOINT and that the service account key is valid"
+		return result
+	}
+	result.OK = true
+	result.Detail = os.Getenv("OMNI_ENDPOINT")
+	return result
+}
+
+func checkSecrets() Result {
+	result := Result{Check: "secrets"}
+	if _, err := secrets.Resolve(secrets.Required...); err != nil {
+		result.Detail = err.Error()
+		result.Hint = "set the secrets in one of the SECRET_SOURCES, see the README"
+		return result
+	}
+	result.OK = true
+	result.Detail = strings.Join(secrets.Required, ", ")
+	return result
+}
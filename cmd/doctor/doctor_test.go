@@ -0,0 +1,119 @@
+package doctor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+// stub replaces the runner with canned outputs keyed by the command line,
+// other commands fail
+func stub(t *testing.T, outputs map[string]string) {
+	t.Helper()
+	runner := utilstest.NewRunner(t, utilstest.Outputs(outputs))
+	runner.Unknown = &utilstest.Response{Stderr: "command failed", Err: utilstest.ExitError(1)}
+	t.Cleanup(utils.SetRunner(runner))
+}
+
+func healthy() map[string]string {
+	return map[string]string{
+		"kubectl version --client -o json": `{"clientVersion":{"gitVersion":"v1.30.1"}}`,
+		"omnictl --version":                "omnictl version v0.37.0",
+		"kubectl get --raw /readyz":        "ok",
+		"kubectl get compositeresourcedefinitions nodegroups.tanuu.dev -o json":  `{"status":{"conditions":[{"type":"Established","status":"True"}]}}`,
+		"kubectl get compositions -l cluster=gke,provider=google -o name":        "composition.apiextensions.crossplane.io/tanuunodegroup\n",
+		"kubectl get functions.pkg.crossplane.io function-go-templating -o json": `{"status":{"conditions":[{"type":"Installed","status":"True"},{"type":"Healthy","status":"True"}]}}`,
+		"kubectl get functions.pkg.crossplane.io function-auto-ready -o json":    `{"status":{"conditions":[{"type":"Installed","status":"True"},{"type":"Healthy","status":"True"}]}}`,
+		"kubectl get providerconfigs.gcp.upbound.io default":                     "default",
+		"omnictl get clusters": "",
+	}
+}
+
+func setenv(t *testing.T) {
+	t.Setenv("KUBECONFIG", "kubeconfig")
+	t.Setenv("OMNI_ENDPOINT", "https://omni.example.com")
+	t.Setenv("OMNI_SERVICE_ACCOUNT_KEY", "key")
+	t.Setenv("SECRET_SOURCES", "env")
+	for _, key := range []string{"TAILSCALE_CLIENT_ID", "TAILSCALE_CLIENT_SECRET", "GITHUB_TOKEN"} {
+		t.Setenv(key, "value")
+	}
+}
+
+func TestRunHealthy(t *testing.T) {
+	setenv(t)
+	stub(t, healthy())
+	results := Run("gcp")
+	for _, result := range results {
+		if !result.OK {
+			t.Errorf("%s failed: %s", result.Check, result.Detail)
+		}
+	}
+	if len(results) != 10 {
+		t.Errorf("got %d results, want 10", len(results))
+	}
+}
+
+func TestRunUnhealthyFunction(t *testing.T) {
+	setenv(t)
+	t.Setenv("GITHUB_TOKEN", "")
+	outputs := healthy()
+	outputs["kubectl get functions.pkg.crossplane.io function-auto-ready -o json"] = `{"status":{"conditions":[{"type":"Installed","status":"True"},{"type":"Healthy","status":"False","reason":"UnhealthyPackageRevision"}]}}`
+	stub(t, outputs)
+	failed := map[string]Result{}
+	for _, result := range Run("gcp") {
+		if !result.OK {
+			failed[result.Check] = result
+		}
+	}
+	if len(failed) != 2 {
+		t.Fatalf("failed checks = %v, want the function and the secrets", failed)
+	}
+	if result := failed["function function-auto-ready"]; !strings.Contains(result.Detail, "UnhealthyPackageRevision") || result.Hint == "" {
+		t.Errorf("function result = %+v", result)
+	}
+	if result := failed["secrets"]; !strings.Contains(result.Detail, "GITHUB_TOKEN") {
+		t.Errorf("secrets result = %+v", result)
+	}
+}
+
+func TestRunUnreachableCluster(t *testing.T) {
+	setenv(t)
+	outputs := healthy()
+	delete(outputs, "kubectl get --raw /readyz")
+	stub(t, outputs)
+	results := Run("gcp")
+	if Healthy(results) {
+		t.Fatal("an unreachable ops cluster is healthy")
+	}
+	for _, result := range results {
+		if strings.HasPrefix(result.Check, "function") || strings.HasPrefix(result.Check, "XRD") {
+			t.Errorf("%s was checked without an ops cluster", result.Check)
+		}
+	}
+}
+
+func TestRunMissingProvider(t *testing.T) {
+	setenv(t)
+	stub(t, healthy())
+	failed := map[string]Result{}
+	for _, result := range Run("aws") {
+		if !result.OK {
+			failed[result.Check] = result
+		}
+	}
+	for _, check := range []string{"composition for aws", "ProviderConfig for aws"} {
+		result, ok := failed[check]
+		if !ok {
+			t.Errorf("%s passed without the aws resources", check)
+			continue
+		}
+		if result.Hint != "run `bootstrap --provider aws` to install it" {
+			t.Errorf("%s hint = %q", check, result.Hint)
+		}
+		if !strings.Contains(result.Detail, "failed") && !strings.Contains(result.Detail, "no composition") {
+			t.Errorf("%s detail = %q", check, result.Detail)
+		}
+	}
+}
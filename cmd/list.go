@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// environmentSummary is a single row of the list command
+type environmentSummary struct {
+	Name       string    `json:"name"`
+	Cluster    bool      `json:"cluster"`
+	NodeGroups []string  `json:"nodeGroups"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// listCmd lists the environments
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list environments",
+	Long:  `List the environments, combining the Omni clusters with the node group claims in the ops cluster.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		clusters, err := utils.ListClusters()
+		if err != nil {
+			log.Fatalf("Error listing clusters: %v", err)
+		}
+		claims, err := utils.ListClaims()
+		if err != nil {
+			log.Fatalf("Error listing claims: %v", err)
+		}
+		environments := map[string]*environmentSummary{}
+		for _, cluster := range clusters {
+			if cluster == "" {
+				continue
+			}
+			environments[cluster] = &environmentSummary{Name: cluster, Cluster: true, NodeGroups: []string{}}
+		}
+		for _, claim := range claims {
+			name := claim.Environment()
+			env, ok := environments[name]
+			if !ok {
+				env = &environmentSummary{Name: name, NodeGroups: []string{}}
+				environments[name] = env
+			}
+			env.NodeGroups = append(env.NodeGroups, claim.Metadata.Name)
+			if env.CreatedAt.IsZero() || claim.Metadata.CreationTimestamp.Before(env.CreatedAt) {
+				env.CreatedAt = claim.Metadata.CreationTimestamp
+			}
+		}
+		summaries := []environmentSummary{}
+		for _, env := range environments {
+			summaries = append(summaries, *env)
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+
+		printResult(format, summaries, func(w io.Writer) {
+			tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(tw, "NAME\tCLUSTER\tNODE GROUPS\tCREATED")
+			for _, env := range summaries {
+				created := ""
+				if !env.CreatedAt.IsZero() {
+					created = env.CreatedAt.Format(time.RFC3339)
+				}
+				fmt.Fprintf(tw, "%s\t%t\t%s\t%s\n", env.Name, env.Cluster, strings.Join(env.NodeGroups, ","), created)
+			}
+			tw.Flush()
+		})
+	},
+}
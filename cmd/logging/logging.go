@@ -0,0 +1,170 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+)
+
+// Options configure the logging of a run
+type Options struct {
+	// Level is the level of the log file, e.g. info or debug
+	Level string
+	// Format is text or json
+	Format string
+	// File is the path of the log file
+	File string
+}
+
+// FromEnv returns the options set with LOG_LEVEL, LOG_FORMAT and LOG_NAME.
+// LOG_NAME names a file in the logs directory of the state directory.
+func FromEnv() (Options, error) {
+	opts := Options{Level: os.Getenv("LOG_LEVEL"), Format: os.Getenv("LOG_FORMAT")}
+	name := os.Getenv("LOG_NAME")
+	if name == "" {
+		name = "app.log"
+	}
+	file, err := state.LogPath(name)
+	if err != nil {
+		return opts, err
+	}
+	opts.File = file
+	return opts, nil
+}
+
+// runID identifies the log entries of this run
+var runID = newRunID()
+
+func newRunID() string {
+	bytes := make([]byte, 4)
+	if _, err := rand.Read(bytes); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(bytes)
+}
+
+// RunID returns the correlation ID added to every log entry of this run
+func RunID() string {
+	return runID
+}
+
+// logfile is the open log file, closed when the logging is configured again
+var logfile io.Closer
+
+// Configure sends the log to a size rotated file at the configured level,
+// while warnings and errors always reach stderr as well.
+func Configure(opts Options) error {
+	level := log.InfoLevel
+	if opts.Level != "" {
+		parsed, err := log.ParseLevel(opts.Level)
+		if err != nil {
+			return fmt.Errorf("invalid log level %q, must be one of panic, fatal, error, warn, info, debug, trace", opts.Level)
+		}
+		level = parsed
+	}
+	var formatter log.Formatter
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		formatter = &log.TextFormatter{DisableColors: true, FullTimestamp: true}
+	case "json":
+		formatter = &log.JSONFormatter{}
+	default:
+		return fmt.Errorf("invalid log format %q, must be text or json", opts.Format)
+	}
+
+	hooks := log.LevelHooks{}
+	// redact and tag the entry before it is written anywhere
+	hooks.Add(secrets.Hook{})
+	hooks.Add(runHook{})
+	hooks.Add(&writerHook{writer: os.Stderr, formatter: formatter, levels: levelsUpTo(log.WarnLevel), pausable: true})
+	if opts.File != "" {
+		file, err := NewRotatingFile(opts.File, DefaultMaxSize, DefaultMaxBackups)
+		if err != nil {
+			return err
+		}
+		if logfile != nil {
+			logfile.Close()
+		}
+		logfile = file
+		hooks.Add(&writerHook{writer: file, formatter: formatter, levels: levelsUpTo(level)})
+	}
+
+	log.SetOutput(io.Discard)
+	log.StandardLogger().ReplaceHooks(hooks)
+	// warnings reach stderr even when the file logs less
+	if level < log.WarnLevel {
+		level = log.WarnLevel
+	}
+	log.SetLevel(level)
+	return nil
+}
+
+// levelsUpTo returns the levels as severe as level or more
+func levelsUpTo(level log.Level) []log.Level {
+	levels := []log.Level{}
+	for _, l := range log.AllLevels {
+		if l <= level {
+			levels = append(levels, l)
+		}
+	}
+	return levels
+}
+
+// stderrPauses counts the callers that keep the log off stderr
+var stderrPauses atomic.Int32
+
+// PauseStderr stops writing log entries to stderr, e.g. while a terminal view
+// owns the screen, until resume is called. The log file and the other hooks
+// keep receiving the entries.
+func PauseStderr() (resume func()) {
+	stderrPauses.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { stderrPauses.Add(-1) }) }
+}
+
+// runHook adds the run ID to every entry
+type runHook struct{}
+
+func (runHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (runHook) Fire(entry *log.Entry) error {
+	entry.Data["run"] = runID
+	return nil
+}
+
+// writerHook writes the entries of its levels to a writer
+type writerHook struct {
+	writer    io.Writer
+	formatter log.Formatter
+	levels    []log.Level
+	// pausable hooks write nothing while PauseStderr is in effect
+	pausable bool
+}
+
+func (h *writerHook) Levels() []log.Level {
+	return h.levels
+}
+
+func (h *writerHook) Fire(entry *log.Entry) error {
+	if h.pausable && stderrPauses.Load() > 0 {
+		return nil
+	}
+	line, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.writer.Write(line)
+	return err
+}
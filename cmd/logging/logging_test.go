@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	file, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := file.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, want := range map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != want {
+			t.Errorf("%s = %q, %v, want %q", filepath.Base(name), data, err, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 backups are kept")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := Configure(Options{Level: "debug", Format: "json", File: path}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	log.Debug("debug entry")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(bytes.TrimSpace(data), &entry); err != nil {
+		t.Fatalf("log file is not json: %v\n%s", err, data)
+	}
+	if entry["msg"] != "debug entry" || entry["run"] != RunID() {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	// an error level file still lets warnings through to stderr
+	if err := Configure(Options{Level: "error", File: path}); err != nil {
+		t.Fatalf("Configure: %v", err)
+	}
+	if !log.IsLevelEnabled(log.WarnLevel) || log.IsLevelEnabled(log.InfoLevel) {
+		t.Errorf("level = %s, want warning", log.GetLevel())
+	}
+	log.Warn("warning entry")
+	data, _ = os.ReadFile(path)
+	if strings.Contains(string(data), "warning entry") {
+		t.Error("warning reached an error level log file")
+	}
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	if err := Configure(Options{Level: "verbose"}); err == nil {
+		t.Error("expected an error for an invalid level")
+	}
+	if err := Configure(Options{Format: "xml"}); err == nil {
+		t.Error("expected an error for an invalid format")
+	}
+}
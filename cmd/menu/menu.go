@@ -10,11 +10,16 @@ import (
 	"time"
 
 	"github.com/charmbracelet/huh"
-	"github.com/charmbracelet/huh/spinner"
 	"github.com/charmbracelet/lipgloss"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/cost"
 	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/destroy"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/naming"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/notify"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/progress"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
 	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
 )
 
@@ -22,7 +27,7 @@ import (
 
 // Menu is the main function for handling the menu.
 func Menu() {
-	environment := utils.Environment{}
+	environment := utils.Environment{Provider: provider.Default}
 
 	log.Info("starting up the menu...")
 	// Should we run in accessible mode?
@@ -65,6 +70,10 @@ func Menu() {
 					Value(&environment.Gpu).
 					Affirmative("Yes!").
 					Negative("No."),
+				huh.NewSelect[string]().
+					Options(huh.NewOptions(provider.Names()...)...).
+					Title("Which cloud should it run in?").
+					Value(&environment.Provider),
 			),
 		).WithAccessible(accessible)
 		err := form.Run()
@@ -78,27 +87,61 @@ func Menu() {
 			log.Info("Exiting...")
 			os.Exit(0)
 		}
-		suffix, err := utils.GenerateRandomString(5)
+		environment.Name, err = naming.Generate(environment.Name, naming.FromEnv())
 		if err != nil {
-			log.Error("Error generating random string: ", err)
+			log.Fatal("Error: ", err)
 		}
-		environment.Name = environment.Name + "-" + suffix
 
-		createenv := func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
-			defer cancel()
-			err := create.Createenvironment(ctx, environment)
+		estimate, err := create.EstimateCost(environment)
+		if err != nil {
+			log.Fatal("Error estimating cost: ", err)
+		}
+		var sb strings.Builder
+		estimate.Print(&sb)
+		fmt.Println(sb.String())
+		if warning := estimate.Warning(cost.Threshold()); warning != "" {
+			proceed := false
+			form := huh.NewForm(
+				huh.NewGroup(
+					huh.NewConfirm().
+						Title(warning + ". Continue?").
+						Value(&proceed).
+						Affirmative("Yes!").
+						Negative("No."),
+				),
+			).WithAccessible(accessible)
+			err := form.Run()
 			if err != nil {
-				if ctx.Err() == context.DeadlineExceeded {
-					log.Fatalf("Command timed out: %v", ctx.Err())
-				} else {
-					log.Fatalf("Error creating environment: %v", err)
-				}
+				log.Fatal("Uh oh:", err)
+			}
+			if !proceed {
+				log.Info("Exiting...")
+				os.Exit(0)
+			}
+		}
+
+		start := time.Now()
+		result := create.Result{}
+		createenv := func(observer create.Observer) error {
+			ctx, cancel := context.WithTimeout(context.Background(), create.Timeout)
+			defer cancel()
+			var err error
+			result, err = create.Createenvironment(ctx, environment, observer)
+			if err != nil && ctx.Err() == context.DeadlineExceeded {
+				return fmt.Errorf("command timed out: %v", ctx.Err())
 			}
+			return err
 		}
-		//spinner while running func
+		// progress view while running func
 		log.Debug("Creating environment with name: ", environment.Name)
-		_ = spinner.New().Title("Preparing your environment...").Accessible(accessible).Action(createenv).Run()
+		err = progress.Run("Preparing your environment "+environment.Name+"...", createenv)
+		if err != nil {
+			notify.Publish(notify.NewEvent(notify.CreateFailed, environment.Name, start, err))
+			log.Fatalf("Error creating environment: %v", err)
+		}
+		event := notify.NewEvent(notify.Created, environment.Name, start, nil)
+		event.Kubeconfig = result.Kubeconfig
+		notify.Publish(event)
 
 		// Print order summary.
 		{
@@ -142,38 +185,52 @@ func Menu() {
 			log.Info("Exiting...")
 			os.Exit(0)
 		}
-		log.Debug("Deleting environment with name: ", environment.Name)
-		utils.DeleteOmniCluster(environment.Name)
-		nodes, err := utils.FindReadyNodes(environment.Name)
+		plan, err := destroy.PlanDeletion(environment.Name)
 		if err != nil {
-			log.Error("Error finding nodes: ", err)
-		} else {
-			for _, node := range nodes {
-				log.Debug("Node: ", node.Metadata.ID, " Hostname: ", node.Spec.Platformmetadata.Hostname)
-				utils.DeleteOmniMachine(node.Metadata.ID)
-			}
+			log.Fatal("Error finding the resources of the environment: ", err)
 		}
-		utils.DeleteNodes(environment.Name)
-		log.Debug("Machines and Cluster deleted")
-		files := []string{
-			environment.Name + "-composition.yaml",
-			environment.Name + "-cluster.yaml",
-			environment.Name + ".kubeconfig",
+		var resources strings.Builder
+		plan.Print(&resources)
+		fmt.Println(resources.String())
+		if len(plan.Protected) > 0 {
+			log.Fatalf("Environment %s is protected, delete it with tanuu delete --force", plan.Name)
 		}
-
-		for _, file := range files {
-			if err := os.Remove(file); err != nil {
-				if !os.IsNotExist(err) {
-					log.Warnf("Failed to remove file: %v", err)
-				}
-			}
+		proceed := false
+		form = huh.NewForm(
+			huh.NewGroup(
+				huh.NewConfirm().
+					Title("Delete these resources?").
+					Value(&proceed).
+					Affirmative("Yes!").
+					Negative("No."),
+			),
+		).WithAccessible(accessible)
+		err = form.Run()
+		if err != nil {
+			log.Fatal("Uh oh:", err)
+		}
+		if !proceed {
+			log.Info("Exiting...")
+			os.Exit(0)
+		}
+		start := time.Now()
+		deleted, err := destroy.Destroyenvironment(plan, false, destroy.DefaultTimeout)
+		if err != nil {
+			log.Error("Error deleting environment: ", err)
+			notify.Publish(notify.NewEvent(notify.DeleteFailed, plan.Name, start, err))
+		} else {
+			notify.Publish(notify.NewEvent(notify.Deleted, plan.Name, start, nil))
 		}
 		var sb strings.Builder
-		log.Debug("Environment Deletion Completed.")
+		title := "Environment Deletion Completed."
+		if err != nil {
+			title = "Environment Deletion Incomplete."
+		}
 		fmt.Fprintf(&sb,
-			"%s",
-			lipgloss.NewStyle().Bold(true).Render("Environment Deletion Completed."),
+			"%s\n\n",
+			lipgloss.NewStyle().Bold(true).Render(title),
 		)
+		deleted.Print(&sb)
 
 		fmt.Println(
 			lipgloss.NewStyle().
@@ -183,6 +240,8 @@ func Menu() {
 				Padding(1, 2).
 				Render(sb.String()),
 		)
-
+		if err != nil {
+			os.Exit(1)
+		}
 	}
 }
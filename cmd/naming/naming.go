@@ -0,0 +1,115 @@
+package naming
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+const (
+	// DefaultTemplate is the naming template used when TANUU_NAME_TEMPLATE is not set
+	DefaultTemplate = "{name}-{suffix}"
+	// SuffixLength is the number of random hex characters of {suffix}
+	SuffixLength = 5
+	// MaxLength is the length limit of GCP instance names and DNS labels
+	MaxLength = 63
+	// instanceSuffix is the length of the "-xxxxx" Kubernetes appends to the
+	// generateName of the VM instances, which become the hostnames of the machines
+	instanceSuffix = 6
+	// tailscaleSuffix is appended to the name for the tailscale hostname of the API server
+	tailscaleSuffix = "-ts"
+)
+
+// label matches a DNS label that is also a valid GCP instance name
+var label = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+
+// invalid matches the characters that cannot be part of a name
+var invalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// Options configure how environment names are generated
+type Options struct {
+	// Template has the placeholders {owner}, {name} and {suffix}
+	Template string
+	// Owner fills {owner}, defaults to TANUU_OWNER or USER
+	Owner string
+	// Exact uses the name as given, without the template
+	Exact bool
+}
+
+// FromEnv reads the options from TANUU_NAME_TEMPLATE, TANUU_OWNER and USER
+func FromEnv() Options {
+	opts := Options{Template: os.Getenv("TANUU_NAME_TEMPLATE"), Owner: os.Getenv("TANUU_OWNER")}
+	if opts.Owner == "" {
+		opts.Owner = os.Getenv("USER")
+	}
+	return opts
+}
+
+// randomSuffix returns the {suffix} of a name
+var randomSuffix = func() (string, error) {
+	return utils.GenerateRandomString(SuffixLength)
+}
+
+// Generate returns the name of a new environment
+func Generate(name string, opts Options) (string, error) {
+	if !validChars(name) {
+		return "", fmt.Errorf("name %q must only contain lowercase letters, numbers, and dashes", name)
+	}
+	generated := name
+	if !opts.Exact {
+		template := opts.Template
+		if template == "" {
+			template = DefaultTemplate
+		}
+		if !strings.Contains(template, "{name}") {
+			return "", fmt.Errorf("naming template %q has no {name}", template)
+		}
+		suffix, err := randomSuffix()
+		if err != nil {
+			return "", fmt.Errorf("generating the name suffix: %w", err)
+		}
+		owner := strings.Trim(invalid.ReplaceAllString(strings.ToLower(opts.Owner), "-"), "-")
+		if strings.Contains(template, "{owner}") && owner == "" {
+			return "", fmt.Errorf("naming template %q needs an owner, set TANUU_OWNER", template)
+		}
+		generated = strings.NewReplacer("{owner}", owner, "{name}", name, "{suffix}", suffix).Replace(template)
+	}
+	if err := Check(generated); err != nil {
+		return "", err
+	}
+	return generated, nil
+}
+
+// validChars reports whether the name is not empty and only has valid characters
+func validChars(name string) bool {
+	return name != "" && !invalid.MatchString(name)
+}
+
+// Check checks that a name can be used as a DNS label and GCP instance name
+func Check(name string) error {
+	if len(name) > MaxLength {
+		return fmt.Errorf("name %s is %d characters long, the limit is %d", name, len(name), MaxLength)
+	}
+	if !label.MatchString(name) {
+		return fmt.Errorf("name %s must start with a letter, end with a letter or number and only contain lowercase letters, numbers, and dashes", name)
+	}
+	return nil
+}
+
+// CheckHostnames checks that the hostnames of the environment fit the limits:
+// the tailscale hostname of the API server and the VM instances of each node group
+func CheckHostnames(name string, groupIDs []string) error {
+	if err := Check(name + tailscaleSuffix); err != nil {
+		return fmt.Errorf("tailscale hostname: %w", err)
+	}
+	for _, id := range groupIDs {
+		hostname := id + "-" + strings.Repeat("x", instanceSuffix-1)
+		if err := Check(hostname); err != nil {
+			return fmt.Errorf("hostnames of node group %s: %w, shorten the environment name", id, err)
+		}
+	}
+	return nil
+}
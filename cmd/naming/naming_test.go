@@ -0,0 +1,74 @@
+package naming
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	original := randomSuffix
+	randomSuffix = func() (string, error) { return "1a2b3", nil }
+	defer func() { randomSuffix = original }()
+
+	tests := []struct {
+		name    string
+		base    string
+		opts    Options
+		want    string
+		wantErr string
+	}{
+		{name: "default template", base: "dev", want: "dev-1a2b3"},
+		{name: "owner template", base: "dev", opts: Options{Template: "{owner}-{name}-{suffix}", Owner: "Jane.Doe"}, want: "jane-doe-dev-1a2b3"},
+		{name: "template without suffix", base: "ci", opts: Options{Template: "{owner}-{name}", Owner: "bot"}, want: "bot-ci"},
+		{name: "exact name", base: "ci-run-42", opts: Options{Template: "{owner}-{name}-{suffix}", Exact: true}, want: "ci-run-42"},
+		{name: "invalid characters", base: "Dev_1", wantErr: "lowercase letters"},
+		{name: "empty name", base: "", wantErr: "lowercase letters"},
+		{name: "template without name", base: "dev", opts: Options{Template: "{owner}-{suffix}", Owner: "bot"}, wantErr: "has no {name}"},
+		{name: "template without owner", base: "dev", opts: Options{Template: "{owner}-{name}"}, wantErr: "TANUU_OWNER"},
+		{name: "starts with a digit", base: "1dev", opts: Options{Exact: true}, wantErr: "must start with a letter"},
+		{name: "ends with a dash", base: "dev-", opts: Options{Exact: true}, wantErr: "must start with a letter"},
+		{name: "too long", base: strings.Repeat("a", 60), wantErr: "the limit is 63"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Generate(tt.base, tt.opts)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Generate() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Generate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckHostnames(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     string
+		groups  []string
+		wantErr string
+	}{
+		{name: "short names", env: "dev-1a2b3", groups: []string{"dev-1a2b3-ctlr-group", "dev-1a2b3-worker-group"}},
+		{name: "instance hostname at the limit", env: "dev", groups: []string{strings.Repeat("a", MaxLength-instanceSuffix)}},
+		{name: "instance hostname over the limit", env: "dev", groups: []string{strings.Repeat("a", MaxLength-instanceSuffix+1)}, wantErr: "node group"},
+		{name: "tailscale hostname over the limit", env: strings.Repeat("a", MaxLength-2), wantErr: "tailscale"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckHostnames(tt.env, tt.groups)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("CheckHostnames() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("CheckHostnames() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/destroy"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/nodegroup"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+var groupRole string
+var groupID string
+var groupReplicas int
+var groupSize string
+var groupDiskSize int
+var groupTimeout time.Duration
+var groupForce bool
+var groupRemoveTimeout time.Duration
+
+// nodeGroupResult is the output of the nodegroup commands
+type nodeGroupResult struct {
+	Name  string `json:"name"`
+	Group string `json:"group"`
+}
+
+// nodegroupCmd groups the node group commands
+var nodegroupCmd = &cobra.Command{
+	Use:   "nodegroup",
+	Short: "add or remove node groups of an environment",
+}
+
+// nodegroupAddCmd adds a node group to an environment
+var nodegroupAddCmd = &cobra.Command{
+	Use:   "add <environment>",
+	Short: "add a node group to an environment",
+	Long:  `Apply a NodeGroupClaim for the new group, wait for its machines and sync the cluster template so they join the cluster.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		size := utils.Size(groupSize)
+		if size == "" {
+			size = utils.SizeMedium
+			if groupRole == "gpu" {
+				size = utils.SizeGPU
+			}
+		}
+		group := utils.NodeGroup{ID: groupID, Role: groupRole, Replicas: groupReplicas, Size: size, DiskSize: groupDiskSize}
+		id, err := nodegroup.Add(args[0], group, groupTimeout)
+		if err != nil {
+			log.Fatalf("Error adding node group: %v", err)
+		}
+		result := nodeGroupResult{Name: args[0], Group: id}
+		printResult(format, result, func(w io.Writer) {
+			fmt.Fprintf(w, "Node group %s added to %s.\n", result.Group, result.Name)
+		})
+	},
+}
+
+// nodegroupRemoveCmd removes a node group from an environment
+var nodegroupRemoveCmd = &cobra.Command{
+	Use:   "remove <environment>",
+	Short: "remove a node group from an environment",
+	Long:  `Sync the cluster template without the group's machines, then delete the machines and the NodeGroupClaim and wait for its VMs to be deleted.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		if err := nodegroup.Remove(args[0], groupID, groupForce, groupRemoveTimeout); err != nil {
+			log.Fatalf("Error removing node group: %v", err)
+		}
+		result := nodeGroupResult{Name: args[0], Group: groupID}
+		printResult(format, result, func(w io.Writer) {
+			fmt.Fprintf(w, "Node group %s removed from %s.\n", result.Group, result.Name)
+		})
+	},
+}
+
+func init() {
+	nodegroupAddCmd.Flags().StringVar(&groupRole, "role", "", "Role of the node group (worker|gpu)")
+	nodegroupAddCmd.MarkFlagRequired("role")
+	nodegroupAddCmd.Flags().StringVar(&groupID, "group", "", "ID of the node group, defaults to <environment>-<role>-group")
+	nodegroupAddCmd.Flags().IntVar(&groupReplicas, "replicas", 1, "Number of nodes in the group")
+	nodegroupAddCmd.Flags().StringVar(&groupSize, "size", "", "Size of the nodes (small|medium|large|gpu)")
+	nodegroupAddCmd.Flags().IntVar(&groupDiskSize, "disk-size", 50, "Boot disk size in GB")
+	nodegroupAddCmd.Flags().DurationVar(&groupTimeout, "timeout", 15*time.Minute, "How long to wait for the machines")
+	nodegroupRemoveCmd.Flags().StringVar(&groupID, "group", "", "ID of the node group to remove")
+	nodegroupRemoveCmd.MarkFlagRequired("group")
+	nodegroupRemoveCmd.Flags().BoolVar(&groupForce, "force", false, "Remove the node group even if it or the environment is protected")
+	nodegroupRemoveCmd.Flags().DurationVar(&groupRemoveTimeout, "timeout", destroy.DefaultTimeout, "How long to wait for the node group to be deleted")
+	nodegroupCmd.AddCommand(nodegroupAddCmd)
+	nodegroupCmd.AddCommand(nodegroupRemoveCmd)
+}
@@ -0,0 +1,174 @@
+package nodegroup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/destroy"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// roles are the roles a node group can be added with, the control plane is
+// created with the environment
+var roles = []string{"worker", "gpu"}
+
+// groupMachines returns the machines that belong to the node group, the VM
+// instances with the hostname of its claim
+func groupMachines(machines []utils.Machine, id string) []utils.Machine {
+	group := utils.GroupInstances{Claim: id}
+	members := []utils.Machine{}
+	for _, machine := range machines {
+		if group.Owns(machine) {
+			members = append(members, machine)
+		}
+	}
+	return members
+}
+
+// resync re-renders the cluster template of the environment with its current
+// versions, manifests and patches and the machines of the node groups, and syncs it to Omni
+func resync(name string, groups []utils.GroupInstances, machines []utils.Machine) error {
+	environment, err := create.LiveCluster(name)
+	if err != nil {
+		return err
+	}
+	err = create.SyncCluster(environment, utils.MachinesByGroup(groups, machines))
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		state.Remove(name, state.ClusterFile)
+	}
+	return err
+}
+
+// Add adds a node group to an existing environment and joins its machines to
+// the cluster. The VMs get the timeout to be ready, and the machines to
+// register in Omni.
+func Add(name string, group utils.NodeGroup, timeout time.Duration) (string, error) {
+	if !slices.Contains(roles, group.Role) {
+		return "", fmt.Errorf("invalid role %q, must be one of %s", group.Role, strings.Join(roles, ", "))
+	}
+	if group.Replicas < 1 {
+		return "", fmt.Errorf("invalid replicas %d, a node group needs at least 1", group.Replicas)
+	}
+	claims, err := utils.EnvironmentClaims(name)
+	if err != nil {
+		return "", err
+	}
+	if group.ID == "" {
+		group.ID = name + "-" + group.Role + "-group"
+	}
+	if !strings.HasPrefix(group.ID, name+"-") || utils.EnvironmentName(group.ID) != name {
+		return "", fmt.Errorf("node group id %s must look like %s-<role>-group", group.ID, name)
+	}
+	for _, claim := range claims {
+		if claim.Metadata.Name == group.ID {
+			return "", fmt.Errorf("node group %s already exists, choose another id with --group", group.ID)
+		}
+	}
+	p, err := provider.FromLabels(claims[0].Spec.CompositionSelector.MatchLabels)
+	if err != nil {
+		return "", err
+	}
+	// the cluster template is synced last, check its secrets before creating machines
+	if _, err := secrets.Resolve(secrets.Required...); err != nil {
+		return "", err
+	}
+
+	var manifest bytes.Buffer
+	environment := utils.Environment{Name: name, Provider: p.Name(), NodeGroups: []utils.NodeGroup{group}}
+	if err := create.RenderClaims(&manifest, environment); err != nil {
+		return "", err
+	}
+	log.Info("Adding node group ", group.ID, " to ", name)
+	if err := utils.ApplyManifest(manifest.Bytes()); err != nil {
+		return "", err
+	}
+	instances := []utils.GroupInstances{{Claim: group.ID, Kind: p.InstanceKind(), Role: group.Role, Replicas: group.Replicas}}
+	if err := utils.WaitForReady(context.Background(), instances, timeout, nil); err != nil {
+		return "", err
+	}
+	if _, err := utils.WaitForMachines(context.Background(), name, instances, timeout); err != nil {
+		return "", err
+	}
+	groups := append(utils.ClaimGroups(claims), instances...)
+	nodes, err := utils.FindGroupMachines(groups)
+	if err != nil {
+		return "", err
+	}
+	return group.ID, resync(name, groups, nodes)
+}
+
+// Remove removes a node group from an existing environment.
+// The machines leave the cluster before their claim is deleted, and the claim
+// and its VMs get the timeout to be deleted. A protected node group or
+// environment is only removed with force.
+func Remove(name, id string, force bool, timeout time.Duration) error {
+	claims, err := utils.EnvironmentClaims(name)
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(claims, func(claim utils.NodeGroupClaim) bool { return claim.Metadata.Name == id })
+	if index < 0 {
+		return fmt.Errorf("environment %s has no node group %s", name, id)
+	}
+	protected := []string{}
+	if claims[index].Protected() {
+		protected = append(protected, "nodegroupclaim "+id)
+	}
+	cluster, err := utils.GetCluster(name)
+	if err != nil {
+		return fmt.Errorf("getting Omni cluster %s: %w", name, err)
+	}
+	if cluster.Metadata.Labels[utils.ProtectedLabel] == "true" {
+		protected = append(protected, "cluster "+name)
+	}
+	if len(protected) > 0 && !force {
+		return fmt.Errorf("%w: %s has the %s=true label on %s, use --force to remove node group %s", destroy.ErrProtected, name, utils.ProtectedLabel, strings.Join(protected, ", "), id)
+	}
+	nodes, err := utils.FindGroupMachines(utils.ClaimGroups(claims))
+	if err != nil {
+		return err
+	}
+	group := utils.GroupInstances{Claim: id}
+	removed, remaining := []utils.Machine{}, []utils.Machine{}
+	for _, machine := range nodes {
+		if group.Owns(machine) {
+			removed = append(removed, machine)
+		} else {
+			remaining = append(remaining, machine)
+		}
+	}
+	groups := []utils.GroupInstances{}
+	for _, group := range utils.ClaimGroups(claims) {
+		if group.Claim != id {
+			groups = append(groups, group)
+		}
+	}
+	if len(utils.MachinesByGroup(groups, remaining)["ctlr"]) == 0 {
+		return fmt.Errorf("cannot remove %s, the environment would have no control plane", id)
+	}
+
+	log.Info("Removing node group ", id, " from ", name)
+	if err := resync(name, groups, remaining); err != nil {
+		return err
+	}
+	for _, machine := range removed {
+		if err := utils.DeleteOmniMachine(machine.Metadata.ID); err != nil {
+			return err
+		}
+	}
+	if err := utils.DeleteClaim(id); err != nil {
+		return err
+	}
+	_, err = utils.WaitForClaimsDeleted([]string{id}, timeout)
+	return err
+}
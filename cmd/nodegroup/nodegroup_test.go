@@ -0,0 +1,131 @@
+package nodegroup
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/destroy"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+// machine is an Omni machine with the hostname
+func machine(id, hostname string) utils.Machine {
+	m := utils.Machine{}
+	m.Metadata.ID = id
+	m.Spec.Platformmetadata.Hostname = hostname
+	m.Spec.Connected = true
+	return m
+}
+
+// machineStatus is a machine in the `omnictl get machinestatus -o json` output
+func machineStatus(id, hostname string) string {
+	return fmt.Sprintf(`{"metadata":{"id":%q},"spec":{"connected":true,"platformmetadata":{"hostname":%q}}}`, id, hostname)
+}
+
+func TestAddRejectsRole(t *testing.T) {
+	for _, role := range []string{"", "ctlr", "highmem"} {
+		_, err := Add("dev-1a2b", utils.NodeGroup{Role: role, Replicas: 1}, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "invalid role") {
+			t.Errorf("Add() with role %q error = %v, want invalid role", role, err)
+		}
+	}
+}
+
+func TestAddRejectsReplicas(t *testing.T) {
+	for _, replicas := range []int{0, -1} {
+		_, err := Add("dev-1a2b", utils.NodeGroup{Role: "worker", Replicas: replicas}, time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "invalid replicas") {
+			t.Errorf("Add() with %d replicas error = %v, want invalid replicas", replicas, err)
+		}
+	}
+}
+
+func TestAddWaitsForMachines(t *testing.T) {
+	for _, key := range []string{"TAILSCALE_CLIENT_ID", "TAILSCALE_CLIENT_SECRET", "GITHUB_TOKEN"} {
+		t.Setenv(key, "test")
+	}
+	runner := utilstest.NewRunner(t, utilstest.Outputs(map[string]string{
+		"kubectl get nodegroupclaims -o json": `{"items":[{"metadata":{"name":"dev-1a2b-ctlr-group","labels":{"tanuu.dev/environment":"dev-1a2b"}},` +
+			`"spec":{"compositionSelector":{"matchLabels":{"provider":"google","cluster":"gke"}}}}]}`,
+		"kubectl apply -f -": "",
+		"kubectl get managed -l crossplane.io/claim-name in (dev-1a2b-highmem-group) -o json": `{"items":[` +
+			`{"kind":"Instance","metadata":{"name":"dev-1a2b-highmem-group-abcde","labels":{"crossplane.io/claim-name":"dev-1a2b-highmem-group"}},"status":{"conditions":[{"type":"Ready","status":"True"}]}},` +
+			`{"kind":"Instance","metadata":{"name":"dev-1a2b-highmem-group-fghij","labels":{"crossplane.io/claim-name":"dev-1a2b-highmem-group"}},"status":{"conditions":[{"type":"Ready","status":"True"}]}}]}`,
+		// one machine of the group, the others only have a similar hostname
+		"omnictl get machinestatus -o json": machineStatus("m1", "dev-1a2b-highmem-group-abcde") +
+			machineStatus("m2", "dev-1a2b-highmem-group2-klmno") + machineStatus("m3", "olddev-1a2b-highmem-group-pqrst"),
+	}))
+	clock := utilstest.NewClock()
+	t.Cleanup(utils.SetRunner(runner))
+	t.Cleanup(utils.SetClock(clock))
+
+	group := utils.NodeGroup{ID: "dev-1a2b-highmem-group", Role: "worker", Replicas: 2, Size: utils.SizeMedium, DiskSize: 50}
+	_, err := Add("dev-1a2b", group, 5*time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "dev-1a2b-highmem-group: 1/2 connected") {
+		t.Fatalf("Add() error = %v, want dev-1a2b-highmem-group: 1/2 connected", err)
+	}
+	if clock.Slept != 5*time.Minute {
+		t.Errorf("slept %v, want the timeout of 5m", clock.Slept)
+	}
+}
+
+func TestGroupMachines(t *testing.T) {
+	machines := []utils.Machine{
+		machine("m1", "dev-1a2b-worker-group-abcde"),
+		machine("m2", "dev-1a2b-worker-group2-fghij"),
+		machine("m3", "olddev-1a2b-worker-group-klmno"),
+		machine("m4", "dev-1a2b-worker-group-pqrst"),
+	}
+	got := []string{}
+	for _, m := range groupMachines(machines, "dev-1a2b-worker-group") {
+		got = append(got, m.Metadata.ID)
+	}
+	if want := []string{"m1", "m4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groupMachines() = %v, want %v", got, want)
+	}
+}
+
+func TestRemoveProtected(t *testing.T) {
+	claims := func(labels string) string {
+		return `{"items":[` +
+			`{"metadata":{"name":"dev-1a2b-ctlr-group","labels":{"tanuu.dev/environment":"dev-1a2b"}}},` +
+			`{"metadata":{"name":"dev-1a2b-gpu-group","labels":` + labels + `}}]}`
+	}
+	tests := []struct {
+		name    string
+		labels  string
+		cluster string
+		want    string
+	}{
+		{
+			name:    "protected node group",
+			labels:  `{"tanuu.dev/environment":"dev-1a2b","protected":"true"}`,
+			cluster: `{"metadata":{"id":"dev-1a2b"}}`,
+			want:    "nodegroupclaim dev-1a2b-gpu-group",
+		},
+		{
+			name:    "protected environment",
+			labels:  `{"tanuu.dev/environment":"dev-1a2b"}`,
+			cluster: `{"metadata":{"id":"dev-1a2b","labels":{"protected":"true"}}}`,
+			want:    "cluster dev-1a2b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing is synced or deleted without force
+			t.Cleanup(utils.SetRunner(utilstest.NewRunner(t, utilstest.Outputs(map[string]string{
+				"kubectl get nodegroupclaims -o json":  claims(tt.labels),
+				"omnictl get cluster dev-1a2b -o json": tt.cluster,
+			}))))
+			err := Remove("dev-1a2b", "dev-1a2b-gpu-group", false, time.Minute)
+			if !errors.Is(err, destroy.ErrProtected) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Remove() error = %v, want ErrProtected on %s", err, tt.want)
+			}
+		})
+	}
+}
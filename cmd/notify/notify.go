@@ -0,0 +1,251 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/naming"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+)
+
+// Types of lifecycle events
+const (
+	Created      = "created"
+	CreateFailed = "create_failed"
+	Deleted      = "deleted"
+	DeleteFailed = "delete_failed"
+)
+
+// maxErrorLength is the length the error summary is cut to
+const maxErrorLength = 500
+
+// Event is a lifecycle event of an environment
+type Event struct {
+	Type        string    `json:"event"`
+	Environment string    `json:"environment"`
+	Owner       string    `json:"owner,omitempty"`
+	Duration    string    `json:"duration"`
+	Kubeconfig  string    `json:"kubeconfig,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+// NewEvent returns an event of the owner in TANUU_OWNER or USER that took the
+// time since start. The error is summarised and its secrets redacted.
+func NewEvent(eventType, environment string, start time.Time, err error) Event {
+	event := Event{
+		Type:        eventType,
+		Environment: environment,
+		Owner:       naming.FromEnv().Owner,
+		Duration:    time.Since(start).Round(time.Second).String(),
+		Time:        time.Now().UTC(),
+	}
+	if err != nil {
+		event.Error = summary(secrets.Redact(err.Error()))
+	}
+	return event
+}
+
+// summary cuts an error to its first line and at most maxErrorLength characters
+func summary(text string) string {
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(text); len(runes) > maxErrorLength {
+		text = string(runes[:maxErrorLength]) + "..."
+	}
+	return text
+}
+
+// failed reports whether the event is a failure
+func (e Event) failed() bool {
+	return e.Type == CreateFailed || e.Type == DeleteFailed
+}
+
+// Title is a one line description of the event
+func (e Event) Title() string {
+	verbs := map[string]string{
+		Created:      "created",
+		CreateFailed: "failed to create",
+		Deleted:      "deleted",
+		DeleteFailed: "failed to delete",
+	}
+	return fmt.Sprintf("Environment %s %s", e.Environment, verbs[e.Type])
+}
+
+// Text is the chat message of the event
+func (e Event) Text() string {
+	lines := []string{e.Title()}
+	details := "Duration: " + e.Duration
+	if e.Owner != "" {
+		details = "Owner: " + e.Owner + ", " + details
+	}
+	lines = append(lines, details)
+	if e.Kubeconfig != "" {
+		lines = append(lines, "Kubeconfig: export KUBECONFIG="+e.Kubeconfig)
+	}
+	if e.Error != "" {
+		lines = append(lines, "Error: "+e.Error)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// payloads render an event in the format of a kind of sink
+var payloads = map[string]func(Event) interface{}{
+	"json": func(e Event) interface{} { return e },
+	"slack": func(e Event) interface{} {
+		return map[string]string{"text": e.Text()}
+	},
+	"teams": func(e Event) interface{} {
+		color := "2EB886"
+		if e.failed() {
+			color = "D00000"
+		}
+		return map[string]string{
+			"@type":      "MessageCard",
+			"@context":   "https://schema.org/extensions",
+			"summary":    e.Title(),
+			"themeColor": color,
+			"title":      e.Title(),
+			"text":       strings.ReplaceAll(e.Text(), "\n", "\n\n"),
+		}
+	},
+}
+
+// Sink is a webhook the events are posted to
+type Sink struct {
+	// Kind is the payload format: json, slack or teams
+	Kind string
+	URL  string
+}
+
+// Parse parses a comma separated list of kind=url sinks, e.g.
+// "slack=https://hooks.slack.com/services/...,json=https://example.com/hook"
+func Parse(spec string) ([]Sink, error) {
+	sinks := []Sink{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		kind, url, found := strings.Cut(entry, "=")
+		if !found || url == "" {
+			return nil, fmt.Errorf("webhook %q must be kind=url", entry)
+		}
+		if _, ok := payloads[kind]; !ok {
+			return nil, fmt.Errorf("unknown webhook kind %q, must be one of json, slack, teams", kind)
+		}
+		// webhook URLs carry their credentials
+		secrets.Register(url)
+		sinks = append(sinks, Sink{Kind: kind, URL: url})
+	}
+	return sinks, nil
+}
+
+// Notifier posts events to webhooks, retrying failed sends
+type Notifier struct {
+	Sinks  []Sink
+	Client *http.Client
+	// Attempts is how often a send is tried
+	Attempts int
+	// Backoff is the wait before the second attempt, doubled for every further one
+	Backoff time.Duration
+}
+
+// New returns a notifier for the sinks with the default retries
+func New(sinks []Sink) *Notifier {
+	return &Notifier{
+		Sinks:    sinks,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Attempts: 3,
+		Backoff:  time.Second,
+	}
+}
+
+// Configured returns a notifier for the sinks in TANUU_WEBHOOKS
+func Configured() (*Notifier, error) {
+	sinks, err := Parse(os.Getenv("TANUU_WEBHOOKS"))
+	if err != nil {
+		return nil, err
+	}
+	return New(sinks), nil
+}
+
+// Send posts the event to every sink and returns the errors of the sinks that failed
+func (n *Notifier) Send(event Event) error {
+	errs := []error{}
+	for _, sink := range n.Sinks {
+		body, err := json.Marshal(payloads[sink.Kind](event))
+		if err != nil {
+			return err
+		}
+		if err := n.post(sink.URL, body); err != nil {
+			errs = append(errs, fmt.Errorf("%s webhook: %w", sink.Kind, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// post sends the body until it is accepted, the attempts are used up or the
+// webhook rejects it with a client error other than 429
+func (n *Notifier) post(url string, body []byte) error {
+	backoff := n.Backoff
+	var err error
+	for attempt := 1; attempt <= n.Attempts; attempt++ {
+		if attempt > 1 {
+			log.Debugf("Retrying webhook in %v: %v", backoff, err)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = n.postOnce(url, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", n.Attempts, err)
+}
+
+// postOnce sends the body once and reports whether a failure is worth retrying
+func (n *Notifier) postOnce(url string, body []byte) (retry bool, err error) {
+	resp, err := n.Client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the error contains the URL
+		return true, errors.New(secrets.Redact(err.Error()))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(message))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+// Notify sends the event and logs a warning when a sink fails, so
+// notifications never fail a command
+func (n *Notifier) Notify(event Event) {
+	if len(n.Sinks) == 0 {
+		return
+	}
+	if err := n.Send(event); err != nil {
+		log.Warnf("Error sending %s notification: %v", event.Type, err)
+	}
+}
+
+// Publish sends the event to the webhooks configured in TANUU_WEBHOOKS
+func Publish(event Event) {
+	notifier, err := Configured()
+	if err != nil {
+		log.Warnf("Error reading TANUU_WEBHOOKS: %v", err)
+		return
+	}
+	notifier.Notify(event)
+}
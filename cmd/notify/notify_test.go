@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+)
+
+// stub is a webhook that answers with the given status codes in order and
+// then keeps answering with the last one
+type stub struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *stub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+	status := s.statuses[0]
+	if len(s.statuses) > 1 {
+		s.statuses = s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newStub(t *testing.T, statuses ...int) (*stub, string) {
+	t.Helper()
+	s := &stub{statuses: statuses}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return s, server.URL
+}
+
+// notifier returns a notifier for the sinks that retries without waiting
+func notifier(sinks ...Sink) *Notifier {
+	n := New(sinks)
+	n.Backoff = time.Millisecond
+	return n
+}
+
+var event = Event{
+	Type:        Created,
+	Environment: "dev-1a2b",
+	Owner:       "jane",
+	Duration:    "12m3s",
+	Kubeconfig:  "/home/jane/.local/state/tanuu/dev-1a2b/kubeconfig",
+	Time:        time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestSendPayloads(t *testing.T) {
+	tests := []struct {
+		kind  string
+		check func(t *testing.T, payload map[string]interface{})
+	}{
+		{kind: "json", check: func(t *testing.T, payload map[string]interface{}) {
+			if payload["event"] != Created || payload["environment"] != "dev-1a2b" || payload["owner"] != "jane" || payload["duration"] != "12m3s" {
+				t.Errorf("payload = %v", payload)
+			}
+		}},
+		{kind: "slack", check: func(t *testing.T, payload map[string]interface{}) {
+			text, _ := payload["text"].(string)
+			if !strings.HasPrefix(text, "Environment dev-1a2b created\n") || !strings.Contains(text, "export KUBECONFIG=/home/jane") {
+				t.Errorf("text = %q", text)
+			}
+		}},
+		{kind: "teams", check: func(t *testing.T, payload map[string]interface{}) {
+			if payload["@type"] != "MessageCard" || payload["title"] != "Environment dev-1a2b created" || payload["themeColor"] != "2EB886" {
+				t.Errorf("payload = %v", payload)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			s, url := newStub(t, http.StatusOK)
+			if err := notifier(Sink{Kind: tt.kind, URL: url}).Send(event); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			if len(s.bodies) != 1 {
+				t.Fatalf("got %d requests, want 1", len(s.bodies))
+			}
+			payload := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(s.bodies[0]), &payload); err != nil {
+				t.Fatalf("payload is not JSON: %v\n%s", err, s.bodies[0])
+			}
+			tt.check(t, payload)
+		})
+	}
+}
+
+func TestSendRetries(t *testing.T) {
+	t.Run("retries server errors", func(t *testing.T) {
+		s, url := newStub(t, http.StatusBadGateway, http.StatusTooManyRequests, http.StatusNoContent)
+		if err := notifier(Sink{Kind: "json", URL: url}).Send(event); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		if len(s.bodies) != 3 {
+			t.Errorf("got %d requests, want 3", len(s.bodies))
+		}
+	})
+	t.Run("gives up after the attempts", func(t *testing.T) {
+		s, url := newStub(t, http.StatusServiceUnavailable)
+		err := notifier(Sink{Kind: "slack", URL: url}).Send(event)
+		if err == nil || !strings.Contains(err.Error(), "giving up after 3 attempts") {
+			t.Fatalf("Send() error = %v, want to give up", err)
+		}
+		if len(s.bodies) != 3 {
+			t.Errorf("got %d requests, want 3", len(s.bodies))
+		}
+	})
+	t.Run("does not retry client errors", func(t *testing.T) {
+		s, url := newStub(t, http.StatusNotFound)
+		err := notifier(Sink{Kind: "teams", URL: url}).Send(event)
+		if err == nil || !strings.Contains(err.Error(), "404") {
+			t.Fatalf("Send() error = %v, want the status", err)
+		}
+		if len(s.bodies) != 1 {
+			t.Errorf("got %d requests, want 1", len(s.bodies))
+		}
+	})
+	t.Run("other sinks still get the event", func(t *testing.T) {
+		_, failing := newStub(t, http.StatusBadRequest)
+		s, url := newStub(t, http.StatusOK)
+		err := notifier(Sink{Kind: "json", URL: failing}, Sink{Kind: "slack", URL: url}).Send(event)
+		if err == nil || !strings.HasPrefix(err.Error(), "json webhook") {
+			t.Fatalf("Send() error = %v, want the json webhook to fail", err)
+		}
+		if len(s.bodies) != 1 {
+			t.Errorf("slack webhook got %d requests, want 1", len(s.bodies))
+		}
+	})
+}
+
+func TestParse(t *testing.T) {
+	sinks, err := Parse("slack=https://hooks.slack.com/services/T0/B0/x?a=b, json=http://localhost:8080/hook")
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(sinks) != 2 || sinks[0] != (Sink{Kind: "slack", URL: "https://hooks.slack.com/services/T0/B0/x?a=b"}) || sinks[1].Kind != "json" {
+		t.Errorf("Parse() = %+v", sinks)
+	}
+	if got := secrets.Redact("posting to https://hooks.slack.com/services/T0/B0/x?a=b"); strings.Contains(got, "T0/B0") {
+		t.Errorf("webhook URL is not redacted: %s", got)
+	}
+	if sinks, err := Parse(""); err != nil || len(sinks) != 0 {
+		t.Errorf("Parse(\"\") = %v, %v, want no sinks", sinks, err)
+	}
+	for _, spec := range []string{"discord=https://example.com", "https://example.com", "slack="} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNewEvent(t *testing.T) {
+	t.Setenv("TANUU_OWNER", "bot")
+	secrets.Register("s3cr3t-token")
+	err := errors.New("applying claims: token s3cr3t-token rejected\nstack trace")
+	got := NewEvent(CreateFailed, "dev-1a2b", time.Now().Add(-90*time.Second), err)
+	if got.Owner != "bot" || got.Duration != "1m30s" || got.Error != "applying claims: token "+secrets.Redacted+" rejected" {
+		t.Errorf("NewEvent() = %+v", got)
+	}
+	if text := got.Text(); !strings.HasPrefix(text, "Environment dev-1a2b failed to create\nOwner: bot, Duration: 1m30s") {
+		t.Errorf("Text() = %q", text)
+	}
+}
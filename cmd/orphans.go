@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/orphans"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+var orphansCleanup bool
+var orphansMinAge time.Duration
+
+// orphansCmd reports the resources left behind by partial failures
+var orphansCmd = &cobra.Command{
+	Use:   "orphans",
+	Short: "find resources that do not belong to a complete environment",
+	Long: `Cross-reference the Omni clusters and machines with the node group claims and Crossplane managed
+resources, and report Omni clusters without claims, claims without an Omni cluster, machines of deleted
+clusters or claims, and managed resources of deleted claims or composites.
+With --cleanup the orphans are removed after confirmation. Resources labelled protected=true are kept.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		inventory, err := orphans.Collect()
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		found := orphans.Detect(inventory, utils.Now(), orphansMinAge)
+		var cleanupErr error
+		if orphansCleanup && len(found) > 0 {
+			orphans.Print(os.Stderr, found)
+			if !assumeYes && !confirm("Remove these resources?") {
+				log.Info("Aborted")
+				return
+			}
+			if cleanupErr = orphans.Cleanup(found); cleanupErr != nil {
+				log.Errorf("Error removing orphans: %v", cleanupErr)
+			}
+		}
+		printResult(format, found, func(w io.Writer) {
+			orphans.Print(w, found)
+		})
+		if cleanupErr != nil {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	orphansCmd.Flags().BoolVar(&orphansCleanup, "cleanup", false, "Remove the orphaned resources")
+	orphansCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+	orphansCmd.Flags().DurationVar(&orphansMinAge, "min-age", orphans.DefaultMinAge, "Skip clusters, claims and managed resources younger than this, they may belong to an environment that is being created")
+}
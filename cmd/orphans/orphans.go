@@ -0,0 +1,247 @@
+package orphans
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// DefaultMinAge is how old clusters, claims and managed resources must be before they
+// count as orphaned, so environments that are still being created are left alone
+const DefaultMinAge = time.Hour
+
+// Kinds of orphaned resources, in the order they are removed
+const (
+	KindCluster = "cluster"
+	KindMachine = "machine"
+	KindClaim   = "nodegroupclaim"
+	KindManaged = "managed"
+)
+
+// clusterLabel is the label Omni puts on the machines of a cluster
+const clusterLabel = "omni.sidero.dev/cluster"
+
+// compositeKind is the kind of the composite resource of a NodeGroupClaim
+const compositeKind = "NodeGroup"
+
+// instanceHostname matches the hostnames of the VM instances composed for a
+// NodeGroupClaim, the claim name with the suffix the composition of any of
+// the providers adds
+var instanceHostname = hostnamePattern()
+
+// hostnamePattern combines the hostname suffixes of the providers
+func hostnamePattern() *regexp.Regexp {
+	suffixes := []string{}
+	for _, name := range provider.Names() {
+		p, err := provider.Get(name)
+		if err != nil {
+			panic(err)
+		}
+		suffixes = append(suffixes, p.HostnameSuffix())
+	}
+	return regexp.MustCompile(`^(.+-group)-(?:` + strings.Join(suffixes, "|") + `)$`)
+}
+
+// Orphan is a resource that does not belong to a complete environment
+type Orphan struct {
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Environment string `json:"environment,omitempty"`
+	Reason      string `json:"reason"`
+	Protected   bool   `json:"protected,omitempty"`
+	Removed     bool   `json:"removed"`
+}
+
+// Inventory is everything Detect cross-references
+type Inventory struct {
+	Clusters   []utils.Cluster
+	Machines   []utils.Machine
+	Claims     []utils.NodeGroupClaim
+	Composites []string
+	Managed    []utils.ManagedResource
+}
+
+// Collect lists the Omni clusters and machines and the claims, composites and
+// managed resources of the ops cluster
+func Collect() (Inventory, error) {
+	inventory := Inventory{}
+	var err error
+	if inventory.Clusters, err = utils.ListOmniClusters(); err != nil {
+		return inventory, err
+	}
+	if inventory.Machines, err = utils.ListMachines(); err != nil {
+		return inventory, fmt.Errorf("listing machines: %w", err)
+	}
+	if inventory.Claims, err = utils.ListClaims(); err != nil {
+		return inventory, fmt.Errorf("listing nodegroupclaims: %w", err)
+	}
+	if inventory.Composites, err = utils.ListComposites(); err != nil {
+		return inventory, err
+	}
+	if inventory.Managed, err = utils.ListManaged(); err != nil {
+		return inventory, err
+	}
+	return inventory, nil
+}
+
+// Detect returns the resources that do not belong to a complete environment,
+// one with both an Omni cluster and NodeGroupClaims:
+//   - Omni clusters of this tool without claims, recognised by the environment
+//     label or by machines with the hostnames of VM instances
+//   - claims without an Omni cluster
+//   - VM instance machines of a deleted Omni cluster, or of a deleted claim
+//   - managed resources composed for a deleted claim or NodeGroup composite
+//
+// Clusters, claims and managed resources younger than minAge are skipped.
+func Detect(inventory Inventory, now time.Time, minAge time.Duration) []Orphan {
+	orphans := []Orphan{}
+	clusters := map[string]bool{}
+	for _, cluster := range inventory.Clusters {
+		clusters[cluster.Metadata.ID] = true
+	}
+	claims := map[string]bool{}
+	environments := map[string]bool{}
+	for _, claim := range inventory.Claims {
+		claims[claim.Metadata.Name] = true
+		environments[claim.Environment()] = true
+	}
+	composites := map[string]bool{}
+	for _, composite := range inventory.Composites {
+		composites[composite] = true
+	}
+	young := func(created time.Time) bool {
+		return !created.IsZero() && now.Sub(created) < minAge
+	}
+
+	// clusters created before the environment label are recognised by the
+	// hostnames of their machines
+	instances := map[string]bool{}
+	for _, machine := range inventory.Machines {
+		if instanceHostname.MatchString(machine.Spec.Platformmetadata.Hostname) {
+			instances[machine.Metadata.Labels[clusterLabel]] = true
+		}
+	}
+	for _, cluster := range inventory.Clusters {
+		name := cluster.Metadata.ID
+		// clusters of other tools on the same Omni account are not ours to judge
+		if cluster.Metadata.Labels[utils.EnvironmentLabel] != name && !instances[name] {
+			continue
+		}
+		if !environments[name] && !young(cluster.Metadata.Created) {
+			orphans = append(orphans, Orphan{
+				Kind: KindCluster, Name: name, Environment: name,
+				Reason:    "no nodegroupclaims",
+				Protected: cluster.Metadata.Labels[utils.ProtectedLabel] == "true",
+			})
+		}
+	}
+	for _, machine := range inventory.Machines {
+		hostname := machine.Spec.Platformmetadata.Hostname
+		match := instanceHostname.FindStringSubmatch(hostname)
+		if match == nil {
+			// not a VM instance of a NodeGroupClaim
+			continue
+		}
+		if cluster := machine.Metadata.Labels[clusterLabel]; cluster != "" {
+			if !clusters[cluster] {
+				orphans = append(orphans, Orphan{Kind: KindMachine, Name: machine.Metadata.ID, Environment: cluster, Reason: fmt.Sprintf("Omni cluster %s does not exist (hostname %s)", cluster, hostname)})
+			}
+			continue
+		}
+		if !claims[match[1]] {
+			orphans = append(orphans, Orphan{Kind: KindMachine, Name: machine.Metadata.ID, Environment: utils.EnvironmentName(match[1]), Reason: fmt.Sprintf("nodegroupclaim %s does not exist (hostname %s)", match[1], hostname)})
+		}
+	}
+	for _, claim := range inventory.Claims {
+		name := claim.Environment()
+		if clusters[name] || young(claim.Metadata.CreationTimestamp) {
+			continue
+		}
+		orphans = append(orphans, Orphan{
+			Kind: KindClaim, Name: claim.Metadata.Name, Environment: name,
+			Reason:    "no Omni cluster " + name,
+			Protected: claim.Protected(),
+		})
+	}
+	for _, resource := range inventory.Managed {
+		if young(resource.Metadata.CreationTimestamp) {
+			continue
+		}
+		// resources composed from other XRDs are not ours to judge
+		composite := resource.Owner(compositeKind)
+		if composite == "" {
+			continue
+		}
+		reason := ""
+		if claim := resource.Claim(); claim != "" && !claims[claim] {
+			reason = "nodegroupclaim " + claim + " does not exist"
+		} else if !composites[composite] {
+			reason = "composite " + composite + " does not exist"
+		}
+		if reason != "" {
+			orphans = append(orphans, Orphan{Kind: KindManaged, Name: resource.Ref(), Environment: utils.EnvironmentName(resource.Claim()), Reason: reason})
+		}
+	}
+	return orphans
+}
+
+// Cleanup removes the orphans that are not protected and marks them removed.
+// It tries every orphan and returns the errors of the ones that failed.
+func Cleanup(orphans []Orphan) error {
+	errs := []error{}
+	for i := range orphans {
+		orphan := &orphans[i]
+		if orphan.Protected {
+			log.Warnf("Skipping protected %s %s", orphan.Kind, orphan.Name)
+			continue
+		}
+		log.Debugf("Removing %s %s", orphan.Kind, orphan.Name)
+		var err error
+		switch orphan.Kind {
+		case KindCluster:
+			err = utils.DeleteOmniCluster(orphan.Name)
+		case KindMachine:
+			err = utils.DeleteOmniMachine(orphan.Name)
+		case KindClaim:
+			err = utils.DeleteClaim(orphan.Name)
+		case KindManaged:
+			err = utils.DeleteResource(orphan.Name)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("removing %s %s: %w", orphan.Kind, orphan.Name, err))
+			continue
+		}
+		orphan.Removed = true
+	}
+	return errors.Join(errs...)
+}
+
+// Print writes the orphans as a table
+func Print(w io.Writer, orphans []Orphan) {
+	if len(orphans) == 0 {
+		fmt.Fprintln(w, "No orphaned resources found.")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tNAME\tENVIRONMENT\tREASON\tSTATUS")
+	for _, orphan := range orphans {
+		status := "orphaned"
+		switch {
+		case orphan.Removed:
+			status = "removed"
+		case orphan.Protected:
+			status = "protected"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", orphan.Kind, orphan.Name, orphan.Environment, orphan.Reason, status)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,86 @@
+package orphans
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// decode fills a resource from its JSON form
+func decode[T any](t *testing.T, data string) T {
+	t.Helper()
+	var resource T
+	if err := json.Unmarshal([]byte(data), &resource); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return resource
+}
+
+func TestDetect(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inventory := Inventory{
+		Clusters: []utils.Cluster{
+			decode[utils.Cluster](t, `{"metadata":{"id":"dev-1a2b"}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"gone-3c4d","labels":{"tanuu.dev/environment":"gone-3c4d"}}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"keep-5e6f","labels":{"tanuu.dev/environment":"keep-5e6f","protected":"true"}}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"legacy-4a5b"}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"fresh-6c7d","labels":{"tanuu.dev/environment":"fresh-6c7d"},"created":"2024-01-01T11:55:00Z"}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"team-prod","created":"2023-01-01T00:00:00Z"}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"aws-8e9f"}}`),
+			decode[utils.Cluster](t, `{"metadata":{"id":"az-0a1b"}}`),
+		},
+		Machines: []utils.Machine{
+			decode[utils.Machine](t, `{"metadata":{"id":"m1","labels":{"omni.sidero.dev/cluster":"dev-1a2b"}},"spec":{"platformmetadata":{"hostname":"dev-1a2b-ctlr-group-x7k2p"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m2","labels":{"omni.sidero.dev/cluster":"old-7a8b"}},"spec":{"platformmetadata":{"hostname":"old-7a8b-ctlr-group-q9z3d"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m3"},"spec":{"platformmetadata":{"hostname":"old-7a8b-worker-group-b4n8c"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m4"},"spec":{"platformmetadata":{"hostname":"dev-1a2b-worker-group-k2m9v"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m5"},"spec":{"platformmetadata":{"hostname":"bare-metal-1"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m6","labels":{"omni.sidero.dev/cluster":"legacy-4a5b"}},"spec":{"platformmetadata":{"hostname":"legacy-4a5b-ctlr-group-h3j4k"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m7","labels":{"omni.sidero.dev/cluster":"team-prod"}},"spec":{"platformmetadata":{"hostname":"prod-node-1"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m8","labels":{"omni.sidero.dev/cluster":"team-old"}},"spec":{"platformmetadata":{"hostname":"old-node-1"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m9","labels":{"omni.sidero.dev/cluster":"aws-8e9f"}},"spec":{"platformmetadata":{"hostname":"aws-8e9f-ctlr-group-3f900","platform":"aws"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m10","labels":{"omni.sidero.dev/cluster":"az-0a1b"}},"spec":{"platformmetadata":{"hostname":"az-0a1b-ctlr-group-0","platform":"azure"}}}`),
+			decode[utils.Machine](t, `{"metadata":{"id":"m11"},"spec":{"platformmetadata":{"hostname":"az-2c3d-worker-group-12","platform":"azure"}}}`),
+		},
+		Claims: []utils.NodeGroupClaim{
+			decode[utils.NodeGroupClaim](t, `{"metadata":{"name":"dev-1a2b-ctlr-group","labels":{"tanuu.dev/environment":"dev-1a2b"}}}`),
+			decode[utils.NodeGroupClaim](t, `{"metadata":{"name":"dev-1a2b-worker-group","creationTimestamp":"2024-01-01T00:00:00Z"}}`),
+			decode[utils.NodeGroupClaim](t, `{"metadata":{"name":"lost-9c0d-ctlr-group","creationTimestamp":"2024-01-01T00:00:00Z"}}`),
+			decode[utils.NodeGroupClaim](t, `{"metadata":{"name":"new-1e2f-ctlr-group","creationTimestamp":"2024-01-01T11:50:00Z"}}`),
+		},
+		Composites: []string{"dev-1a2b-ctlr-group-abcde"},
+		Managed: []utils.ManagedResource{
+			decode[utils.ManagedResource](t, `{"apiVersion":"compute.gcp.upbound.io/v1beta1","kind":"Instance","metadata":{"name":"dev-1a2b-ctlr-group-x7k2p","labels":{"crossplane.io/claim-name":"dev-1a2b-ctlr-group"},"ownerReferences":[{"kind":"NodeGroup","name":"dev-1a2b-ctlr-group-abcde"}]}}`),
+			decode[utils.ManagedResource](t, `{"apiVersion":"compute.gcp.upbound.io/v1beta1","kind":"Instance","metadata":{"name":"old-7a8b-worker-group-b4n8c","labels":{"crossplane.io/claim-name":"old-7a8b-worker-group"},"ownerReferences":[{"kind":"NodeGroup","name":"old-7a8b-worker-group-fghij"}]}}`),
+			decode[utils.ManagedResource](t, `{"apiVersion":"compute.gcp.upbound.io/v1beta1","kind":"Disk","metadata":{"name":"dev-1a2b-ctlr-group-disk","labels":{"crossplane.io/claim-name":"dev-1a2b-ctlr-group"},"ownerReferences":[{"kind":"NodeGroup","name":"dev-1a2b-ctlr-group-zzzzz"}]}}`),
+			decode[utils.ManagedResource](t, `{"apiVersion":"storage.gcp.upbound.io/v1beta1","kind":"Bucket","metadata":{"name":"other","ownerReferences":[{"kind":"XBucket","name":"other-xyz"}]}}`),
+		},
+	}
+
+	got := Detect(inventory, now, time.Hour)
+	want := []Orphan{
+		{Kind: KindCluster, Name: "gone-3c4d", Environment: "gone-3c4d", Reason: "no nodegroupclaims"},
+		{Kind: KindCluster, Name: "keep-5e6f", Environment: "keep-5e6f", Reason: "no nodegroupclaims", Protected: true},
+		{Kind: KindCluster, Name: "legacy-4a5b", Environment: "legacy-4a5b", Reason: "no nodegroupclaims"},
+		{Kind: KindCluster, Name: "aws-8e9f", Environment: "aws-8e9f", Reason: "no nodegroupclaims"},
+		{Kind: KindCluster, Name: "az-0a1b", Environment: "az-0a1b", Reason: "no nodegroupclaims"},
+		{Kind: KindMachine, Name: "m2", Environment: "old-7a8b", Reason: "Omni cluster old-7a8b does not exist (hostname old-7a8b-ctlr-group-q9z3d)"},
+		{Kind: KindMachine, Name: "m3", Environment: "old-7a8b", Reason: "nodegroupclaim old-7a8b-worker-group does not exist (hostname old-7a8b-worker-group-b4n8c)"},
+		{Kind: KindMachine, Name: "m11", Environment: "az-2c3d", Reason: "nodegroupclaim az-2c3d-worker-group does not exist (hostname az-2c3d-worker-group-12)"},
+		{Kind: KindClaim, Name: "lost-9c0d-ctlr-group", Environment: "lost-9c0d", Reason: "no Omni cluster lost-9c0d"},
+		{Kind: KindManaged, Name: "instance.compute.gcp.upbound.io/old-7a8b-worker-group-b4n8c", Environment: "old-7a8b", Reason: "nodegroupclaim old-7a8b-worker-group does not exist"},
+		{Kind: KindManaged, Name: "disk.compute.gcp.upbound.io/dev-1a2b-ctlr-group-disk", Environment: "dev-1a2b", Reason: "composite dev-1a2b-ctlr-group-zzzzz does not exist"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Detect() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestDetectNothing(t *testing.T) {
+	if got := Detect(Inventory{}, time.Now(), time.Hour); len(got) != 0 {
+		t.Errorf("Detect() = %+v, want no orphans", got)
+	}
+}
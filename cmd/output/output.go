@@ -0,0 +1,51 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Format is a machine readable output format
+type Format string
+
+const (
+	// Human is the default human readable output
+	Human Format = ""
+	// JSON prints the result as an indented JSON document
+	JSON Format = "json"
+	// YAML prints the result as a YAML document
+	YAML Format = "yaml"
+)
+
+// Parse parses the value of the -o flag
+func Parse(value string) (Format, error) {
+	switch Format(value) {
+	case Human, JSON, YAML:
+		return Format(value), nil
+	}
+	return Human, fmt.Errorf("unknown output format %q, must be json or yaml", value)
+}
+
+// Print writes v to w in the given machine readable format
+func Print(w io.Writer, format Format, v interface{}) error {
+	switch format {
+	case JSON:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case YAML:
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	return fmt.Errorf("output format %q is not machine readable", format)
+}
@@ -0,0 +1,239 @@
+package progress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/logging"
+)
+
+// maxWarnings is the number of warnings shown in the warnings pane
+const maxWarnings = 5
+
+var (
+	titleStyle   = lipgloss.NewStyle().Bold(true)
+	doneStyle    = lipgloss.NewStyle().Foreground(lipgloss.Color("42"))
+	failedStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	pendingStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	warningStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+	paneStyle    = lipgloss.NewStyle().
+			BorderStyle(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("63")).
+			Padding(0, 1)
+)
+
+// phaseState is the state of a single phase in the view
+type phaseState struct {
+	phase   create.Phase
+	detail  string
+	started time.Time
+	ended   time.Time
+	err     error
+}
+
+// eventMsg carries a progress event into the program
+type eventMsg create.Event
+
+// warningMsg carries a logged warning into the program
+type warningMsg string
+
+// doneMsg is sent when the work has finished
+type doneMsg struct{ err error }
+
+// tickMsg refreshes the elapsed times
+type tickMsg time.Time
+
+// model is the bubbletea model of the progress view
+type model struct {
+	title    string
+	phases   []*phaseState
+	warnings []string
+	spinner  spinner.Model
+	err      error
+	done     bool
+}
+
+func newModel(title string) model {
+	m := model{title: title, spinner: spinner.New(spinner.WithSpinner(spinner.Dot))}
+	for _, phase := range create.Phases {
+		m.phases = append(m.phases, &phaseState{phase: phase})
+	}
+	return m
+}
+
+func tick() tea.Cmd {
+	return tea.Tick(time.Second, func(t time.Time) tea.Msg { return tickMsg(t) })
+}
+
+func (m model) Init() tea.Cmd {
+	return tea.Batch(m.spinner.Tick, tick())
+}
+
+func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			m.err = fmt.Errorf("interrupted")
+			return m, tea.Quit
+		}
+	case eventMsg:
+		for _, state := range m.phases {
+			if state.phase != msg.Phase {
+				continue
+			}
+			switch msg.Type {
+			case create.PhaseStarted:
+				state.started = msg.Time
+			case create.PhaseFinished:
+				state.ended = msg.Time
+			case create.PhaseFailed:
+				state.ended = msg.Time
+				state.err = errors.New(msg.Error)
+			}
+			if msg.Detail != "" && msg.Type != create.MachineRegistered {
+				state.detail = msg.Detail
+			}
+		}
+	case warningMsg:
+		m.warnings = append(m.warnings, string(msg))
+		if len(m.warnings) > maxWarnings {
+			m.warnings = m.warnings[len(m.warnings)-maxWarnings:]
+		}
+	case doneMsg:
+		m.done = true
+		m.err = msg.err
+		return m, tea.Quit
+	case tickMsg:
+		return m, tick()
+	case spinner.TickMsg:
+		var cmd tea.Cmd
+		m.spinner, cmd = m.spinner.Update(msg)
+		return m, cmd
+	}
+	return m, nil
+}
+
+func (m model) View() string {
+	var sb strings.Builder
+	sb.WriteString(titleStyle.Render(m.title) + "\n\n")
+	for _, state := range m.phases {
+		sb.WriteString(state.line(m.spinner.View()) + "\n")
+	}
+	if len(m.warnings) > 0 {
+		sb.WriteString("\n" + paneStyle.Render(warningStyle.Render(strings.Join(m.warnings, "\n"))) + "\n")
+	}
+	return sb.String()
+}
+
+// line renders the phase with its status and elapsed time
+func (s *phaseState) line(spin string) string {
+	name := string(s.phase)
+	if s.detail != "" {
+		name += " (" + s.detail + ")"
+	}
+	switch {
+	case s.started.IsZero():
+		return pendingStyle.Render("  · " + name)
+	case s.err != nil:
+		return failedStyle.Render(fmt.Sprintf("  ✗ %s %s: %v", name, elapsed(s.started, s.ended), s.err))
+	case !s.ended.IsZero():
+		return doneStyle.Render("  ✓ "+name) + " " + elapsed(s.started, s.ended)
+	}
+	return "  " + spin + name + " " + elapsed(s.started, time.Now())
+}
+
+// elapsed formats the time between start and end
+func elapsed(start, end time.Time) string {
+	return pendingStyle.Render(end.Sub(start).Round(time.Second).String())
+}
+
+// warningHook forwards logged warnings and errors to the progress view
+type warningHook struct {
+	send func(tea.Msg)
+}
+
+func (h warningHook) Levels() []log.Level {
+	return []log.Level{log.ErrorLevel, log.WarnLevel}
+}
+
+func (h warningHook) Fire(entry *log.Entry) error {
+	h.send(warningMsg(entry.Time.Format("15:04:05") + " " + entry.Message))
+	return nil
+}
+
+// addWarningHook adds a warningHook to the configured hooks, pauses the log on
+// stderr so it does not tear the view, and returns a function that restores
+// both. The other configured hooks keep running, so the entry is redacted by
+// secrets.Hook before it reaches the view and still reaches the log file.
+func addWarningHook(send func(tea.Msg)) (restore func()) {
+	logger := log.StandardLogger()
+	hooks := log.LevelHooks{}
+	for level, configured := range logger.Hooks {
+		hooks[level] = append([]log.Hook{}, configured...)
+	}
+	hooks.Add(warningHook{send: send})
+	resume := logging.PauseStderr()
+	previous := logger.ReplaceHooks(hooks)
+	return func() {
+		logger.ReplaceHooks(previous)
+		resume()
+	}
+}
+
+// Run runs the work while showing the progress of each creation phase.
+// Without a terminal the events are printed line by line.
+func Run(title string, work func(observer create.Observer) error) error {
+	if !isatty.IsTerminal(os.Stderr.Fd()) {
+		fmt.Fprintln(os.Stderr, title)
+		return work(LinePrinter(os.Stderr))
+	}
+
+	program := tea.NewProgram(newModel(title), tea.WithOutput(os.Stderr))
+	defer addWarningHook(program.Send)()
+	go func() {
+		err := work(create.ObserverFunc(func(event create.Event) {
+			program.Send(eventMsg(event))
+		}))
+		program.Send(doneMsg{err: err})
+	}()
+
+	final, err := program.Run()
+	if err != nil {
+		return err
+	}
+	return final.(model).err
+}
+
+// LinePrinter prints phase events line by line
+func LinePrinter(w io.Writer) create.Observer {
+	return create.ObserverFunc(func(event create.Event) {
+		var status string
+		switch event.Type {
+		case create.PhaseStarted:
+			status = "started"
+		case create.PhaseProgressed:
+			status = "running"
+		case create.PhaseFinished:
+			status = "done in " + event.Duration.Round(time.Second).String()
+		case create.PhaseFailed:
+			status = "failed after " + event.Duration.Round(time.Second).String() + ": " + event.Error
+		default:
+			return
+		}
+		if event.Detail != "" {
+			status += " (" + event.Detail + ")"
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", event.Time.Format("15:04:05"), event.Phase, status)
+	})
+}
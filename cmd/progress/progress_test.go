@@ -0,0 +1,77 @@
+package progress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/logging"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+)
+
+func TestWarningHookKeepsConfiguredHooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := logging.Configure(logging.Options{File: path}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+	secrets.Register("s3cr3t-token")
+
+	warnings := []string{}
+	restore := addWarningHook(func(msg tea.Msg) {
+		warnings = append(warnings, string(msg.(warningMsg)))
+	})
+	log.Warn("token s3cr3t-token rejected")
+	restore()
+	log.Warn("after the view")
+
+	if len(warnings) != 1 || strings.Contains(warnings[0], "s3cr3t-token") || !strings.Contains(warnings[0], "token "+secrets.Redacted+" rejected") {
+		t.Errorf("warnings = %q, want the redacted warning only", warnings)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := string(data)
+	if strings.Contains(file, "s3cr3t-token") || !strings.Contains(file, "token "+secrets.Redacted+" rejected") || !strings.Contains(file, "after the view") {
+		t.Errorf("log file = %q, want both warnings redacted", file)
+	}
+}
+
+func TestWarningHookPausesStderr(t *testing.T) {
+	stderr, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	original := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = original }()
+	if err := logging.Configure(logging.Options{}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.StandardLogger().ReplaceHooks(log.LevelHooks{}) })
+
+	warnings := 0
+	restore := addWarningHook(func(tea.Msg) { warnings++ })
+	log.Warn("while the view runs")
+	log.Error("failed while the view runs")
+	restore()
+
+	data, err := os.ReadFile(stderr.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 || warnings != 2 {
+		t.Errorf("stderr = %q with %d warnings in the view, want nothing on stderr", data, warnings)
+	}
+	log.Warn("after the view")
+	data, _ = os.ReadFile(stderr.Name())
+	if !strings.Contains(string(data), "after the view") {
+		t.Errorf("stderr = %q, want the warning after the view", data)
+	}
+}
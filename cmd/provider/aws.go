@@ -0,0 +1,60 @@
+package provider
+
+import (
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// aws creates node groups as EC2 instances
+type aws struct {
+	catalog
+	region     string
+	zone       string
+	volumeType string
+}
+
+func newAWS() Provider {
+	region := getenv("AWS_REGION", "eu-west-1")
+	return &aws{
+		catalog: catalog{
+			name: "aws",
+			machines: map[utils.Size]string{
+				utils.SizeSmall:  "r5.large",
+				utils.SizeMedium: "r5.xlarge",
+				utils.SizeLarge:  "r5.2xlarge",
+				utils.SizeGPU:    "g6.8xlarge",
+			},
+			// AMIs are region specific, so there are no defaults
+			images: map[string]string{},
+		},
+		region:     region,
+		zone:       getenv("AWS_ZONE", region+"a"),
+		volumeType: getenv("AWS_VOLUME_TYPE", "gp3"),
+	}
+}
+
+func (a *aws) Name() string {
+	return "aws"
+}
+
+func (a *aws) Labels() map[string]string {
+	return map[string]string{"provider": "aws", "cluster": "ec2"}
+}
+
+func (a *aws) InstanceKind() string {
+	return "Instance"
+}
+
+func (a *aws) HostnameSuffix() string {
+	return `[0-9a-f]{3}[0-9]{2,}`
+}
+
+func (a *aws) Parameters(group utils.NodeGroup) (utils.NodeGroupParameters, error) {
+	params, err := a.common(group)
+	if err != nil {
+		return params, err
+	}
+	params.ImageType = a.volumeType
+	params.Zone = a.zone
+	params.Region = a.region
+	return params, nil
+}
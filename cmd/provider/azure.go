@@ -0,0 +1,74 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// azure creates node groups as Linux virtual machines
+type azure struct {
+	catalog
+	location      string
+	resourceGroup string
+	subnetID      string
+	storageType   string
+	sshPublicKey  string
+}
+
+func newAzure() Provider {
+	return &azure{
+		catalog: catalog{
+			name: "azure",
+			machines: map[utils.Size]string{
+				utils.SizeSmall:  "Standard_E2s_v5",
+				utils.SizeMedium: "Standard_E4s_v5",
+				utils.SizeLarge:  "Standard_E8s_v5",
+				utils.SizeGPU:    "Standard_NC24ads_A100_v4",
+			},
+			// images live in a subscription specific gallery, so there are no defaults
+			images: map[string]string{},
+		},
+		location:      getenv("AZURE_LOCATION", "westeurope"),
+		resourceGroup: getenv("AZURE_RESOURCE_GROUP", "tanuu"),
+		subnetID:      getenv("AZURE_SUBNET_ID", ""),
+		storageType:   getenv("AZURE_STORAGE_TYPE", "Premium_LRS"),
+		sshPublicKey:  getenv("AZURE_SSH_PUBLIC_KEY", ""),
+	}
+}
+
+func (a *azure) Name() string {
+	return "azure"
+}
+
+func (a *azure) Labels() map[string]string {
+	return map[string]string{"provider": "azure", "cluster": "vm"}
+}
+
+func (a *azure) InstanceKind() string {
+	return "LinuxVirtualMachine"
+}
+
+func (a *azure) HostnameSuffix() string {
+	return `[0-9]+`
+}
+
+func (a *azure) Parameters(group utils.NodeGroup) (utils.NodeGroupParameters, error) {
+	params, err := a.common(group)
+	if err != nil {
+		return params, err
+	}
+	if a.subnetID == "" {
+		return params, fmt.Errorf("no azure subnet, set AZURE_SUBNET_ID")
+	}
+	// Azure refuses virtual machines without a login, Talos ignores it
+	if a.sshPublicKey == "" {
+		return params, fmt.Errorf("no azure ssh public key, set AZURE_SSH_PUBLIC_KEY")
+	}
+	params.ImageType = a.storageType
+	params.Zone = a.location
+	params.ResourceGroup = a.resourceGroup
+	params.SubnetID = a.subnetID
+	params.SSHPublicKey = a.sshPublicKey
+	return params, nil
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// gcp creates node groups as Compute Engine instances
+type gcp struct {
+	catalog
+	project        string
+	zone           string
+	diskType       string
+	serviceAccount string
+}
+
+func newGCP() Provider {
+	project := getenv("GCP_PROJECT", "silogen-sandbox")
+	return &gcp{
+		catalog: catalog{
+			name: "gcp",
+			machines: map[utils.Size]string{
+				utils.SizeSmall:  "e2-highmem-2",
+				utils.SizeMedium: "e2-highmem-4",
+				utils.SizeLarge:  "e2-highmem-8",
+				utils.SizeGPU:    "g2-standard-24",
+			},
+			images: map[string]string{
+				"worker": "projects/" + project + "/global/images/omni-worker-v5",
+				"ctlr":   "projects/" + project + "/global/images/omni-ctrl-v4",
+				"gpu":    "projects/" + project + "/global/images/omni-gpu-v4",
+			},
+		},
+		project:        project,
+		zone:           getenv("GCP_ZONE", "europe-west4-a"),
+		diskType:       getenv("GCP_DISK_TYPE", "pd-balanced"),
+		serviceAccount: getenv("GCP_SERVICE_ACCOUNT", "1067721308413-compute@developer.gserviceaccount.com"),
+	}
+}
+
+func (g *gcp) Name() string {
+	return "gcp"
+}
+
+func (g *gcp) Labels() map[string]string {
+	return map[string]string{"provider": "google", "cluster": "gke"}
+}
+
+func (g *gcp) InstanceKind() string {
+	return "Instance"
+}
+
+func (g *gcp) HostnameSuffix() string {
+	return `[a-z0-9]{5}`
+}
+
+func (g *gcp) Parameters(group utils.NodeGroup) (utils.NodeGroupParameters, error) {
+	params, err := g.common(group)
+	if err != nil {
+		return params, err
+	}
+	params.ImageType = "projects/" + g.project + "/zones/" + g.zone + "/diskTypes/" + g.diskType
+	params.ServiceAccountEmail = g.serviceAccount
+	params.Zone = g.zone
+	return params, nil
+}
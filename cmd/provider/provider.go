@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// Default is the provider used when none is given
+const Default = "gcp"
+
+// defaultDiskSize is the boot disk size in GB used when a node group does not set one
+const defaultDiskSize = 50
+
+// Provider maps provider independent node groups onto the parameters of a
+// NodeGroup composition for one cloud.
+type Provider interface {
+	// Name returns the name used to select the provider
+	Name() string
+	// Labels returns the labels that select the provider's composition
+	Labels() map[string]string
+	// InstanceKind returns the kind of the VM resources the composition creates, one per replica
+	InstanceKind() string
+	// HostnameSuffix returns the regular expression of the suffix the
+	// composition adds to the claim name in the hostnames of the VM instances
+	HostnameSuffix() string
+	// Parameters returns the claim parameters for a node group
+	Parameters(group utils.NodeGroup) (utils.NodeGroupParameters, error)
+}
+
+var providers = map[string]func() Provider{
+	"gcp":   newGCP,
+	"aws":   newAWS,
+	"azure": newAzure,
+}
+
+// Get returns the provider with the given name
+func Get(name string) (Provider, error) {
+	if name == "" {
+		name = Default
+	}
+	newProvider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %q, must be one of %s", name, strings.Join(Names(), ", "))
+	}
+	return newProvider(), nil
+}
+
+// FromLabels returns the provider whose composition labels match the labels of a claim
+func FromLabels(labels map[string]string) (Provider, error) {
+	for _, name := range Names() {
+		p := providers[name]()
+		matches := true
+		for key, value := range p.Labels() {
+			if labels[key] != value {
+				matches = false
+			}
+		}
+		if matches {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no provider for composition labels %v", labels)
+}
+
+// Names returns the names of all providers
+func Names() []string {
+	names := []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DefaultNodeGroups returns the node groups of a standard environment
+func DefaultNodeGroups(gpu bool) []utils.NodeGroup {
+	groups := []utils.NodeGroup{
+		{Role: "worker", Replicas: 2, Size: utils.SizeMedium, DiskSize: defaultDiskSize},
+		{Role: "ctlr", Replicas: 1, Size: utils.SizeMedium, DiskSize: defaultDiskSize},
+	}
+	if gpu {
+		groups = append(groups, utils.NodeGroup{Role: "gpu", Replicas: 1, Size: utils.SizeGPU, DiskSize: defaultDiskSize})
+	}
+	return groups
+}
+
+// catalog holds the provider specific values a node group is mapped onto
+type catalog struct {
+	name     string
+	machines map[utils.Size]string
+	images   map[string]string
+}
+
+// getenv returns the environment variable or the fallback when it is not set
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// machineType returns the machine type for a generic size
+func (c catalog) machineType(size utils.Size) (string, error) {
+	if size == "" {
+		size = utils.SizeMedium
+	}
+	machine, ok := c.machines[size]
+	if !ok {
+		return "", fmt.Errorf("size %q is not available on %s", size, c.name)
+	}
+	return machine, nil
+}
+
+// image returns the boot image for a role, overridable with <PROVIDER>_IMAGE_<ROLE>
+func (c catalog) image(role string) (string, error) {
+	key := strings.ToUpper(c.name + "_IMAGE_" + role)
+	image := getenv(key, c.images[role])
+	if image == "" {
+		return "", fmt.Errorf("no %s image for role %s, set %s", c.name, role, key)
+	}
+	return image, nil
+}
+
+// common fills the parameters every provider shares
+func (c catalog) common(group utils.NodeGroup) (utils.NodeGroupParameters, error) {
+	params := utils.NodeGroupParameters{Replicas: group.Replicas, Size: group.DiskSize}
+	if params.Size == 0 {
+		params.Size = defaultDiskSize
+	}
+	params.MachineType = group.MachineType
+	if params.MachineType == "" {
+		machine, err := c.machineType(group.Size)
+		if err != nil {
+			return params, err
+		}
+		params.MachineType = machine
+	}
+	params.Image = group.Image
+	if params.Image == "" {
+		image, err := c.image(group.Role)
+		if err != nil {
+			return params, err
+		}
+		params.Image = image
+	}
+	return params, nil
+}
@@ -0,0 +1,110 @@
+package provider
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+		err  string
+	}{
+		{name: "", want: Default},
+		{name: "gcp", want: "gcp"},
+		{name: "aws", want: "aws"},
+		{name: "azure", want: "azure"},
+		{name: "oracle", err: `unknown provider "oracle", must be one of aws, azure, gcp`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := Get(tt.name)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("Get(%q) error = %v, want %s", tt.name, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Get(%q) error = %v", tt.name, err)
+			}
+			if p.Name() != tt.want {
+				t.Errorf("Get(%q) = %s, want %s", tt.name, p.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestFromLabels(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string
+	}{
+		{name: "gcp", labels: map[string]string{"provider": "google", "cluster": "gke"}, want: "gcp"},
+		{name: "aws", labels: map[string]string{"provider": "aws", "cluster": "ec2"}, want: "aws"},
+		{name: "azure with extra labels", labels: map[string]string{"provider": "azure", "cluster": "vm", "team": "ml"}, want: "azure"},
+		{name: "partial match", labels: map[string]string{"provider": "aws"}},
+		{name: "no labels"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := FromLabels(tt.labels)
+			if tt.want == "" {
+				if err == nil || !strings.Contains(err.Error(), "no provider for composition labels") {
+					t.Errorf("FromLabels(%v) = %v, %v, want an error", tt.labels, p, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromLabels(%v) error = %v", tt.labels, err)
+			}
+			if p.Name() != tt.want {
+				t.Errorf("FromLabels(%v) = %s, want %s", tt.labels, p.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultNodeGroups(t *testing.T) {
+	worker := utils.NodeGroup{Role: "worker", Replicas: 2, Size: utils.SizeMedium, DiskSize: defaultDiskSize}
+	ctlr := utils.NodeGroup{Role: "ctlr", Replicas: 1, Size: utils.SizeMedium, DiskSize: defaultDiskSize}
+	gpu := utils.NodeGroup{Role: "gpu", Replicas: 1, Size: utils.SizeGPU, DiskSize: defaultDiskSize}
+	if got, want := DefaultNodeGroups(false), []utils.NodeGroup{worker, ctlr}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DefaultNodeGroups(false) = %+v, want %+v", got, want)
+	}
+	if got, want := DefaultNodeGroups(true), []utils.NodeGroup{worker, ctlr, gpu}; !reflect.DeepEqual(got, want) {
+		t.Errorf("DefaultNodeGroups(true) = %+v, want %+v", got, want)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	// aws and azure have no default images, azure no default network
+	t.Setenv("AZURE_SUBNET_ID", "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/default")
+	t.Setenv("AZURE_SSH_PUBLIC_KEY", "ssh-ed25519 AAAA test")
+	for _, role := range []string{"WORKER", "CTLR", "GPU"} {
+		t.Setenv("AWS_IMAGE_"+role, "ami-0123456789abcdef0")
+		t.Setenv("AZURE_IMAGE_"+role, "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/images/talos")
+	}
+	for _, name := range Names() {
+		t.Run(name, func(t *testing.T) {
+			p, err := Get(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, group := range DefaultNodeGroups(true) {
+				params, err := p.Parameters(group)
+				if err != nil {
+					t.Fatalf("Parameters(%s) error = %v", group.Role, err)
+				}
+				if params.Replicas != group.Replicas || params.Size != defaultDiskSize || params.MachineType == "" || params.Image == "" {
+					t.Errorf("Parameters(%s) = %+v", group.Role, params)
+				}
+			}
+		})
+	}
+}
@@ -1,16 +1,25 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
-	"regexp"
+	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/cost"
 	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/logging"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/naming"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/notify"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/output"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/progress"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/provider"
 	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
 )
 
@@ -18,22 +27,115 @@ var rootCmd = &cobra.Command{
 	Use:   "tannu-omni",
 	Short: "App to create environments",
 	Long:  `This application creates environments for you to work in.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		opts, err := logging.FromEnv()
+		if err != nil {
+			return err
+		}
+		if logLevel != "" {
+			opts.Level = logLevel
+		}
+		if logFormat != "" {
+			opts.Format = logFormat
+		}
+		if logFile != "" {
+			opts.File = logFile
+		}
+		if err := logging.Configure(opts); err != nil {
+			return err
+		}
+		// doctor reports missing settings itself
+		if cmd == doctorCmd {
+			return nil
+		}
+		return utils.CheckOmni()
+	},
 }
 
 // Execute runs the root command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVarP(&outputFlag, "output", "o", "", "Output format (json|yaml)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Level of the log file (error|warn|info|debug|trace), defaults to LOG_LEVEL or info")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Log format (text|json), defaults to LOG_FORMAT or text")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Path of the log file, defaults to LOG_NAME in the logs directory of the state directory")
 	rootCmd.AddCommand(createCmd)
+	rootCmd.AddCommand(costCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(describeCmd)
+	rootCmd.AddCommand(deleteCmd)
+	rootCmd.AddCommand(upgradeCmd)
+	rootCmd.AddCommand(nodegroupCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(cleanCmd)
+	rootCmd.AddCommand(orphansCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(bootstrapCmd)
 }
 
+var outputFlag string
+var logLevel string
+var logFormat string
+var logFile string
 var name string
 var gpu bool
+var cloud string
+var assumeYes bool
+var costThreshold float64
+var exactName bool
+var nameTemplate string
+
+// outputFormat returns the format selected with -o
+func outputFormat() output.Format {
+	format, err := output.Parse(outputFlag)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return format
+}
+
+// printResult writes the result to stdout in the selected format.
+// Human readable output goes to stderr so stdout stays clean for piping.
+func printResult(format output.Format, result interface{}, human func(w io.Writer)) {
+	if format == output.Human {
+		human(os.Stderr)
+		return
+	}
+	if err := output.Print(os.Stdout, format, result); err != nil {
+		log.Fatalf("Error writing output: %v", err)
+	}
+}
+
+// confirm asks a yes/no question on the terminal
+func confirm(question string) bool {
+	fmt.Fprintf(os.Stderr, "%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// environmentName generates the name of a new environment with the naming flags
+func environmentName(base string) string {
+	opts := naming.FromEnv()
+	if nameTemplate != "" {
+		opts.Template = nameTemplate
+	}
+	opts.Exact = exactName
+	generated, err := naming.Generate(base, opts)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	return generated
+}
 
 // helloCmd represents the hello command
 var createCmd = &cobra.Command{
@@ -41,31 +143,47 @@ var createCmd = &cobra.Command{
 	Short: "create an environment",
 	Long:  `Create an environment.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		match, _ := regexp.MatchString("^[a-z0-9-]+$", name)
-		if !match {
-			log.Fatalf("Error: name must only contain lowercase letters, numbers, and dashes")
-		}
+		format := outputFormat()
 		environment := utils.Environment{}
-		suffix, err := utils.GenerateRandomString(5)
+		environment.Name = environmentName(name)
+		environment.Gpu = gpu
+		if _, err := provider.Get(cloud); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		environment.Provider = cloud
+		estimate, err := create.EstimateCost(environment)
 		if err != nil {
-			log.Error("Error generating random string: ", err)
+			log.Fatalf("Error estimating cost: %v", err)
+		}
+		estimate.Print(os.Stderr)
+		if warning := estimate.Warning(costThreshold); warning != "" && !assumeYes {
+			if !confirm(warning + ", continue?") {
+				log.Info("Environment creation cancelled")
+				os.Exit(1)
+			}
 		}
-		environment.Name = name + "-" + suffix
-		environment.Gpu = gpu
 		log.Info("Creating environment with name: ", environment.Name)
-		createenv := func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+		start := time.Now()
+		createenv := func() create.Result {
+			ctx, cancel := context.WithTimeout(context.Background(), create.Timeout)
 			defer cancel()
-			err := create.Createenvironment(ctx, environment)
+			result, err := create.Createenvironment(ctx, environment, progress.LinePrinter(os.Stderr))
 			if err != nil {
 				if ctx.Err() == context.DeadlineExceeded {
-					log.Fatalf("Command timed out: %v", ctx.Err())
-				} else {
-					log.Fatalf("Error creating environment: %v", err)
+					err = fmt.Errorf("command timed out: %v", ctx.Err())
 				}
+				notify.Publish(notify.NewEvent(notify.CreateFailed, environment.Name, start, err))
+				log.Fatalf("Error creating environment: %v", err)
 			}
+			return result
 		}
-		createenv()
+		result := createenv()
+		event := notify.NewEvent(notify.Created, result.Name, start, nil)
+		event.Kubeconfig = result.Kubeconfig
+		notify.Publish(event)
+		printResult(format, result, func(w io.Writer) {
+			fmt.Fprintf(w, "Environment %s created.\nEndpoint: %s\nKubeconfig: %s\n", result.Name, result.Endpoint, result.Kubeconfig)
+		})
 
 	},
 }
@@ -74,5 +192,10 @@ func init() {
 	createCmd.Flags().StringVarP(&name, "name", "n", "", "Name of the environment to create")
 	createCmd.MarkFlagRequired("name")
 	createCmd.Flags().BoolVarP(&gpu, "gpu", "g", false, "Enable GPU for the environment")
+	createCmd.Flags().StringVarP(&cloud, "provider", "p", provider.Default, "Cloud provider to create the environment in ("+strings.Join(provider.Names(), "|")+")")
+	createCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "Do not ask for confirmation")
+	createCmd.Flags().Float64Var(&costThreshold, "cost-threshold", cost.Threshold(), "Hourly cost above which to ask for confirmation")
+	createCmd.Flags().BoolVar(&exactName, "exact-name", false, "Use the name as given, without the naming template")
+	createCmd.Flags().StringVar(&nameTemplate, "name-template", "", "Naming template with {owner}, {name} and {suffix}, defaults to TANUU_NAME_TEMPLATE or "+naming.DefaultTemplate)
 
 }
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Redacted replaces secret values in logs and debug output
+const Redacted = "[REDACTED]"
+
+// MinLength is the length a secret needs to be redacted. Shorter values
+// would mangle unrelated text.
+const MinLength = 4
+
+var (
+	mu       sync.RWMutex
+	values   = map[string]bool{}
+	replacer = strings.NewReplacer()
+)
+
+// Register marks a value as secret so it is redacted. Values shorter than
+// MinLength are ignored.
+func Register(value string) {
+	if len(value) < MinLength {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if values[value] {
+		return
+	}
+	values[value] = true
+	// the longest secret wins where secrets overlap, so no part of it is left
+	sorted := make([]string, 0, len(values))
+	for value := range values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if len(sorted[i]) != len(sorted[j]) {
+			return len(sorted[i]) > len(sorted[j])
+		}
+		return sorted[i] < sorted[j]
+	})
+	pairs := make([]string, 0, 2*len(sorted))
+	for _, value := range sorted {
+		pairs = append(pairs, value, Redacted)
+	}
+	replacer = strings.NewReplacer(pairs...)
+}
+
+// Redact replaces every registered secret in text
+func Redact(text string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return replacer.Replace(text)
+}
+
+// Hook redacts the registered secrets from log entries
+type Hook struct{}
+
+// Levels returns all levels, every entry is redacted
+func (Hook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire redacts the message and the fields of the entry
+func (Hook) Fire(entry *log.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = Redact(value)
+		case error:
+			entry.Data[key] = Redact(value.Error())
+		case fmt.Stringer:
+			entry.Data[key] = Redact(value.String())
+		}
+	}
+	return nil
+}
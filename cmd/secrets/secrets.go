@@ -0,0 +1,112 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Source looks up secrets by key
+type Source interface {
+	// Name returns the name used to select the source
+	Name() string
+	// Lookup returns the secret and whether the source has it
+	Lookup(key string) (string, bool, error)
+}
+
+// DefaultSources are the sources used when SECRET_SOURCES is not set
+const DefaultSources = "env"
+
+// Required are the secrets rendered into the cluster template
+var Required = []string{"TAILSCALE_CLIENT_ID", "TAILSCALE_CLIENT_SECRET", "GITHUB_TOKEN"}
+
+var sources = map[string]func() Source{
+	"env":     newEnv,
+	"file":    newFile,
+	"command": newCommand,
+	"vault":   newVault,
+}
+
+// Get returns the source with the given name
+func Get(name string) (Source, error) {
+	newSource, ok := sources[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown secret source %q, must be one of env, file, command, vault", name)
+	}
+	return newSource(), nil
+}
+
+// Chain looks up secrets in its sources in order, the first source that has a secret wins
+type Chain []Source
+
+// Configured returns the chain of sources named in SECRET_SOURCES, e.g. "env,vault"
+func Configured() (Chain, error) {
+	names := os.Getenv("SECRET_SOURCES")
+	if names == "" {
+		names = DefaultSources
+	}
+	chain := Chain{}
+	for _, name := range strings.Split(names, ",") {
+		source, err := Get(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, source)
+	}
+	return chain, nil
+}
+
+// Resolve looks up all keys and registers the values for redaction.
+// Every missing key is reported in a single error.
+func (c Chain) Resolve(keys ...string) (map[string]string, error) {
+	values := map[string]string{}
+	missing := []string{}
+	for _, key := range keys {
+		value, err := c.lookup(key)
+		if err != nil {
+			return nil, err
+		}
+		if value == "" {
+			missing = append(missing, key)
+			continue
+		}
+		Register(value)
+		values[key] = value
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required secrets %s, set them in one of the secret sources (%s)", strings.Join(missing, ", "), c)
+	}
+	return values, nil
+}
+
+// lookup returns the secret from the first source that has it
+func (c Chain) lookup(key string) (string, error) {
+	for _, source := range c {
+		value, ok, err := source.Lookup(key)
+		if err != nil {
+			return "", fmt.Errorf("secret source %s: %w", source.Name(), err)
+		}
+		if ok && value != "" {
+			return value, nil
+		}
+	}
+	return "", nil
+}
+
+// String lists the names of the sources
+func (c Chain) String() string {
+	names := []string{}
+	for _, source := range c {
+		names = append(names, source.Name())
+	}
+	return strings.Join(names, ", ")
+}
+
+// Resolve resolves the keys from the configured sources
+func Resolve(keys ...string) (map[string]string, error) {
+	chain, err := Configured()
+	if err != nil {
+		return nil, err
+	}
+	return chain.Resolve(keys...)
+}
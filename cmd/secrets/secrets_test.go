@@ -0,0 +1,149 @@
+package secrets
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tanuu.env")
+	data := "# secrets\nexport GITHUB_TOKEN=\"ghp_file\"\nTAILSCALE_CLIENT_ID = id\n"
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	source := file{path: path}
+	for key, want := range map[string]string{"GITHUB_TOKEN": "ghp_file", "TAILSCALE_CLIENT_ID": "id"} {
+		value, ok, err := source.Lookup(key)
+		if err != nil || !ok || value != want {
+			t.Errorf("Lookup(%s) = %q, %t, %v, want %q", key, value, ok, err, want)
+		}
+	}
+	if _, ok, _ := source.Lookup("TAILSCALE_CLIENT_SECRET"); ok {
+		t.Error("found a key that is not in the file")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	source := command{args: []string{"echo", "secret-{key}"}}
+	value, ok, err := source.Lookup("GITHUB_TOKEN")
+	if err != nil || !ok || value != "secret-GITHUB_TOKEN" {
+		t.Errorf("Lookup = %q, %t, %v", value, ok, err)
+	}
+	source = command{args: []string{"false"}}
+	if _, ok, err := source.Lookup("GITHUB_TOKEN"); ok || err != nil {
+		t.Errorf("failing command: ok=%t err=%v, want not found", ok, err)
+	}
+	source = command{args: []string{"tanuu-no-such-command", "{key}"}}
+	if _, ok, err := source.Lookup("GITHUB_TOKEN"); ok || err == nil || !strings.Contains(err.Error(), "tanuu-no-such-command") {
+		t.Errorf("missing command: ok=%t err=%v, want an error", ok, err)
+	}
+}
+
+func TestVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/v1/secret/data/tanuu" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"GITHUB_TOKEN":"ghp_vault"},"metadata":{"version":1}}}`))
+	}))
+	defer server.Close()
+	t.Setenv("VAULT_ADDR", server.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+	t.Setenv("VAULT_SECRET_PATH", "")
+
+	source := newVault()
+	value, ok, err := source.Lookup("GITHUB_TOKEN")
+	if err != nil || !ok || value != "ghp_vault" {
+		t.Errorf("Lookup = %q, %t, %v", value, ok, err)
+	}
+	if _, ok, err := source.Lookup("TAILSCALE_CLIENT_ID"); ok || err != nil {
+		t.Errorf("missing field: ok=%t err=%v", ok, err)
+	}
+
+	t.Setenv("VAULT_TOKEN", "wrong")
+	if _, _, err := newVault().Lookup("GITHUB_TOKEN"); err == nil {
+		t.Error("expected an error for a forbidden token")
+	}
+}
+
+func TestResolveMissing(t *testing.T) {
+	t.Setenv("SECRET_SOURCES", "env")
+	t.Setenv("TAILSCALE_CLIENT_ID", "id")
+	t.Setenv("TAILSCALE_CLIENT_SECRET", "")
+	t.Setenv("GITHUB_TOKEN", "")
+	_, err := Resolve(Required...)
+	if err == nil {
+		t.Fatal("expected an error for missing secrets")
+	}
+	for _, key := range []string{"TAILSCALE_CLIENT_SECRET", "GITHUB_TOKEN"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not name %s", err, key)
+		}
+	}
+}
+
+func TestResolveChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tanuu.env")
+	if err := os.WriteFile(path, []byte("GITHUB_TOKEN=ghp_from_file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SECRETS_FILE", path)
+	t.Setenv("SECRET_SOURCES", "env, file")
+	t.Setenv("GITHUB_TOKEN", "")
+	values, err := Resolve("GITHUB_TOKEN")
+	if err != nil || values["GITHUB_TOKEN"] != "ghp_from_file" {
+		t.Errorf("Resolve = %v, %v", values, err)
+	}
+	t.Setenv("SECRET_SOURCES", "env,keychain")
+	if _, err := Resolve("GITHUB_TOKEN"); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+}
+
+func TestHook(t *testing.T) {
+	Register("s3cr3t-value")
+	var out bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&out)
+	logger.AddHook(Hook{})
+	logger.WithField("token", "s3cr3t-value").Infof("syncing with token s3cr3t-value")
+	if strings.Contains(out.String(), "s3cr3t-value") || !strings.Contains(out.String(), Redacted) {
+		t.Errorf("secret was not redacted: %s", out.String())
+	}
+}
+
+func TestRedactOverlapping(t *testing.T) {
+	// the shorter secrets are parts of the longer one
+	for _, value := range []string{"ef12", "abcdef123456", "abcd", "x9", ""} {
+		Register(value)
+	}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"token abcdef123456 set", "token " + Redacted + " set"},
+		{"abcd and ef12", Redacted + " and " + Redacted},
+		{"abcdef12", Redacted + Redacted},
+		// too short to be redacted
+		{"x9 stays", "x9 stays"},
+	}
+	for i := 0; i < 10; i++ {
+		for _, tt := range tests {
+			if got := Redact(tt.text); got != tt.want {
+				t.Fatalf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// getenv returns the environment variable or the fallback when it is not set
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// env reads secrets from the process environment
+type env struct{}
+
+func newEnv() Source { return env{} }
+
+func (env) Name() string { return "env" }
+
+func (env) Lookup(key string) (string, bool, error) {
+	value, ok := os.LookupEnv(key)
+	return value, ok, nil
+}
+
+// file reads secrets from a dotenv file of KEY=VALUE lines, named by SECRETS_FILE
+type file struct {
+	path string
+}
+
+func newFile() Source { return file{path: getenv("SECRETS_FILE", "tanuu.env")} }
+
+func (f file) Name() string { return "file" }
+
+func (f file) Lookup(key string) (string, bool, error) {
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if !found || strings.TrimSpace(name) != key {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		return value, true, nil
+	}
+	return "", false, scanner.Err()
+}
+
+// command reads secrets from the first line a command prints, like `pass show`.
+// SECRETS_COMMAND is split on spaces and {key} is replaced by the key.
+type command struct {
+	args []string
+}
+
+func newCommand() Source {
+	return command{args: strings.Fields(getenv("SECRETS_COMMAND", "pass show tanuu/{key}"))}
+}
+
+func (c command) Name() string { return "command" }
+
+func (c command) Lookup(key string) (string, bool, error) {
+	if len(c.args) == 0 {
+		return "", false, fmt.Errorf("SECRETS_COMMAND is empty")
+	}
+	args := []string{}
+	for _, arg := range c.args {
+		args = append(args, strings.ReplaceAll(arg, "{key}", key))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	output, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", false, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		// a command that exits non-zero does not have the secret
+		return "", false, nil
+	}
+	if err != nil {
+		// the command could not run at all, e.g. it is not installed
+		return "", false, fmt.Errorf("running %s: %w", args[0], err)
+	}
+	value, _, _ := strings.Cut(string(output), "\n")
+	return strings.TrimSpace(value), true, nil
+}
+
+// vault reads secrets from a Vault KV version 2 secret.
+// The keys are fields of the secret at VAULT_SECRET_PATH.
+type vault struct {
+	addr   string
+	token  string
+	path   string
+	client *http.Client
+}
+
+func newVault() Source {
+	return &vault{
+		addr:   strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		token:  os.Getenv("VAULT_TOKEN"),
+		path:   getenv("VAULT_SECRET_PATH", "secret/data/tanuu"),
+		client: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (v *vault) Name() string { return "vault" }
+
+func (v *vault) Lookup(key string) (string, bool, error) {
+	if v.addr == "" {
+		return "", false, fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest(http.MethodGet, v.addr+"/v1/"+strings.TrimPrefix(v.path, "/"), nil)
+	if err != nil {
+		return "", false, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", false, fmt.Errorf("reading %s: %s", v.path, resp.Status)
+	}
+	secret := struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", false, fmt.Errorf("parsing %s: %w", v.path, err)
+	}
+	value, ok := secret.Data.Data[key]
+	return value, ok, nil
+}
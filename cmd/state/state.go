@@ -0,0 +1,133 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Artifacts of an environment, stored in its state directory
+const (
+	// CompositionFile holds the rendered NodeGroupClaims
+	CompositionFile = "composition.yaml"
+	// ClusterFile holds the rendered Omni cluster template
+	ClusterFile = "cluster.yaml"
+	// KubeconfigFile is the kubeconfig of the environment
+	KubeconfigFile = "kubeconfig"
+)
+
+// Dir returns the state directory: TANUU_STATE_DIR, $XDG_STATE_HOME/tanuu
+// or ~/.local/state/tanuu
+func Dir() string {
+	if dir := os.Getenv("TANUU_STATE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "tanuu")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(".tanuu", "state")
+	}
+	return filepath.Join(home, ".local", "state", "tanuu")
+}
+
+// environmentsDir holds one directory per environment
+func environmentsDir() string {
+	return filepath.Join(Dir(), "environments")
+}
+
+// mkdir creates the directory and its parents, readable only by the user
+func mkdir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	return os.Chmod(dir, 0700)
+}
+
+// EnvironmentDir returns the state directory of an environment
+func EnvironmentDir(name string) string {
+	return filepath.Join(environmentsDir(), name)
+}
+
+// Path returns the path of an artifact of an environment
+func Path(name, file string) string {
+	return filepath.Join(EnvironmentDir(name), file)
+}
+
+// Exists reports whether the environment has the artifact
+func Exists(name, file string) bool {
+	_, err := os.Stat(Path(name, file))
+	return err == nil
+}
+
+// Create creates or truncates an artifact of an environment, readable only by the user
+func Create(name, file string) (*os.File, error) {
+	if err := mkdir(EnvironmentDir(name)); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(Path(name, file), os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	// the file may exist from an earlier run with wider permissions
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFile writes an artifact of an environment, readable only by the user
+func WriteFile(name, file string, data []byte) error {
+	f, err := Create(name, file)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Remove removes an artifact of an environment, a missing artifact is not an error
+func Remove(name, file string) error {
+	if err := os.Remove(Path(name, file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// RemoveEnvironment removes the state directory of an environment
+func RemoveEnvironment(name string) error {
+	return os.RemoveAll(EnvironmentDir(name))
+}
+
+// Environments returns the names of the environments with a state directory
+func Environments() ([]string, error) {
+	entries, err := os.ReadDir(environmentsDir())
+	if os.IsNotExist(err) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// LogPath returns the path of a log file in the logs directory of the state directory
+func LogPath(file string) (string, error) {
+	dir := filepath.Join(Dir(), "logs")
+	if err := mkdir(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, file), nil
+}
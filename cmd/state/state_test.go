@@ -0,0 +1,78 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	t.Setenv("TANUU_STATE_DIR", "")
+	t.Setenv("XDG_STATE_HOME", "/tmp/xdg")
+	if dir := Dir(); dir != "/tmp/xdg/tanuu" {
+		t.Errorf("Dir() = %s, want /tmp/xdg/tanuu", dir)
+	}
+	t.Setenv("TANUU_STATE_DIR", "/tmp/tanuu")
+	if dir := Dir(); dir != "/tmp/tanuu" {
+		t.Errorf("Dir() = %s, want /tmp/tanuu", dir)
+	}
+}
+
+func TestPermissions(t *testing.T) {
+	t.Setenv("TANUU_STATE_DIR", t.TempDir())
+	// an artifact left behind with wider permissions is tightened
+	if err := os.MkdirAll(EnvironmentDir("test-1a2b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(Path("test-1a2b", ClusterFile), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile("test-1a2b", ClusterFile, []byte("token: secret")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	checkMode(t, EnvironmentDir("test-1a2b"), 0700)
+	checkMode(t, Path("test-1a2b", ClusterFile), 0600)
+
+	if _, err := LogPath("app.log"); err != nil {
+		t.Fatalf("LogPath: %v", err)
+	}
+	checkMode(t, filepath.Join(Dir(), "logs"), 0700)
+}
+
+func TestEnvironments(t *testing.T) {
+	t.Setenv("TANUU_STATE_DIR", t.TempDir())
+	names, err := Environments()
+	if err != nil || len(names) != 0 {
+		t.Fatalf("Environments() = %v, %v on an empty state directory", names, err)
+	}
+	for _, name := range []string{"b-2222", "a-1111"} {
+		if err := WriteFile(name, KubeconfigFile, []byte("kind: Config")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, _ = Environments()
+	if !reflect.DeepEqual(names, []string{"a-1111", "b-2222"}) {
+		t.Errorf("Environments() = %v", names)
+	}
+	if err := RemoveEnvironment("a-1111"); err != nil {
+		t.Fatal(err)
+	}
+	if Exists("a-1111", KubeconfigFile) || !Exists("b-2222", KubeconfigFile) {
+		t.Error("RemoveEnvironment removed the wrong environment")
+	}
+	if err := Remove("b-2222", ClusterFile); err != nil {
+		t.Errorf("Remove of a missing artifact: %v", err)
+	}
+}
+
+func checkMode(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != want {
+		t.Errorf("%s has mode %o, want %o", path, info.Mode().Perm(), want)
+	}
+}
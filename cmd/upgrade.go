@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/upgrade"
+)
+
+var kubernetesVersion string
+var talosVersion string
+var upgradeTimeout time.Duration
+
+// upgradeResult is the output of the upgrade command
+type upgradeResult struct {
+	Name              string `json:"name"`
+	KubernetesVersion string `json:"kubernetesVersion,omitempty"`
+	TalosVersion      string `json:"talosVersion,omitempty"`
+}
+
+// upgradeCmd upgrades Kubernetes and Talos of an environment
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade <environment>",
+	Short: "upgrade kubernetes and talos of an environment",
+	Long:  `Re-render the cluster template of an environment with new Kubernetes and Talos versions, sync it to Omni and wait for the rollout.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		format := outputFormat()
+		if kubernetesVersion == "" && talosVersion == "" {
+			log.Fatalf("Error: set --kubernetes-version and/or --talos-version")
+		}
+		err := upgrade.Upgradeenvironment(args[0], kubernetesVersion, talosVersion, upgradeTimeout)
+		if err != nil {
+			log.Fatalf("Error upgrading environment: %v", err)
+		}
+		result := upgradeResult{Name: args[0], KubernetesVersion: kubernetesVersion, TalosVersion: talosVersion}
+		printResult(format, result, func(w io.Writer) {
+			fmt.Fprintf(w, "Environment %s upgraded.\n", result.Name)
+		})
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().StringVar(&kubernetesVersion, "kubernetes-version", "", "Kubernetes version to upgrade to, e.g. v1.30.1")
+	upgradeCmd.Flags().StringVar(&talosVersion, "talos-version", "", "Talos version to upgrade to, e.g. v1.7.2")
+	upgradeCmd.Flags().DurationVar(&upgradeTimeout, "timeout", 30*time.Minute, "How long to wait for the rollout")
+}
@@ -0,0 +1,194 @@
+package upgrade
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/create"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+)
+
+// version is a parsed major.minor.patch version
+type version struct {
+	major, minor, patch int
+}
+
+// parseVersion parses versions like v1.29.4 or 1.29.4
+func parseVersion(value string) (version, error) {
+	parts := strings.Split(strings.TrimPrefix(value, "v"), ".")
+	if len(parts) != 3 {
+		return version{}, fmt.Errorf("invalid version %q, expected vX.Y.Z", value)
+	}
+	numbers := []int{}
+	for _, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil {
+			return version{}, fmt.Errorf("invalid version %q, expected vX.Y.Z", value)
+		}
+		numbers = append(numbers, number)
+	}
+	return version{numbers[0], numbers[1], numbers[2]}, nil
+}
+
+// String formats the version with a leading v
+func (v version) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.major, v.minor, v.patch)
+}
+
+// less reports whether v is older than other
+func (v version) less(other version) bool {
+	if v.major != other.major {
+		return v.major < other.major
+	}
+	if v.minor != other.minor {
+		return v.minor < other.minor
+	}
+	return v.patch < other.patch
+}
+
+// ValidateVersionJump checks that component can be upgraded from one version to another.
+// Downgrades, major upgrades and skipping minor versions are refused.
+func ValidateVersionJump(component, from, to string) error {
+	current, err := parseVersion(from)
+	if err != nil {
+		return fmt.Errorf("current %s version: %w", component, err)
+	}
+	target, err := parseVersion(to)
+	if err != nil {
+		return fmt.Errorf("target %s version: %w", component, err)
+	}
+	switch {
+	case target.less(current):
+		return fmt.Errorf("cannot downgrade %s from %s to %s", component, current, target)
+	case target.major != current.major:
+		return fmt.Errorf("cannot upgrade %s across major versions from %s to %s", component, current, target)
+	case target.minor > current.minor+1:
+		return fmt.Errorf("cannot upgrade %s from %s to %s, upgrade to v%d.%d first", component, current, target, current.major, current.minor+1)
+	}
+	return nil
+}
+
+// sameVersion compares versions with or without a leading v
+func sameVersion(a, b string) bool {
+	return strings.TrimPrefix(a, "v") == strings.TrimPrefix(b, "v")
+}
+
+// Upgradeenvironment upgrades Kubernetes and Talos of an existing environment
+// and waits up to the timeout for the rollout. An empty version keeps the
+// version the cluster runs now.
+func Upgradeenvironment(name, kubernetesVersion, talosVersion string, timeout time.Duration) error {
+	environment, err := create.LiveCluster(name)
+	if err != nil {
+		return err
+	}
+	currentKubernetes := environment.KubernetesVersion
+	currentTalos := environment.TalosVersion
+	if kubernetesVersion == "" {
+		kubernetesVersion = currentKubernetes
+	}
+	if talosVersion == "" {
+		talosVersion = currentTalos
+	}
+	if err := ValidateVersionJump("kubernetes", currentKubernetes, kubernetesVersion); err != nil {
+		return err
+	}
+	if err := ValidateVersionJump("talos", currentTalos, talosVersion); err != nil {
+		return err
+	}
+	if sameVersion(currentKubernetes, kubernetesVersion) && sameVersion(currentTalos, talosVersion) {
+		log.Infof("Environment %s already runs kubernetes %s and talos %s", name, kubernetesVersion, talosVersion)
+		return nil
+	}
+	log.Infof("Upgrading %s from kubernetes %s, talos %s to kubernetes %s, talos %s", name, currentKubernetes, currentTalos, kubernetesVersion, talosVersion)
+
+	claims, err := utils.EnvironmentClaims(name)
+	if err != nil {
+		return err
+	}
+	nodes, err := utils.FindClusterMachines(name)
+	if err != nil {
+		return err
+	}
+	environment.KubernetesVersion = kubernetesVersion
+	environment.TalosVersion = talosVersion
+	err = create.SyncCluster(environment, utils.MachinesByGroup(utils.ClaimGroups(claims), nodes))
+	if !log.IsLevelEnabled(log.DebugLevel) {
+		state.Remove(name, state.ClusterFile)
+	}
+	if err != nil {
+		return err
+	}
+	// only the components that change get a new upgrade status
+	if sameVersion(currentKubernetes, kubernetesVersion) {
+		kubernetesVersion = ""
+	}
+	if sameVersion(currentTalos, talosVersion) {
+		talosVersion = ""
+	}
+	return WaitForVersions(name, kubernetesVersion, talosVersion, timeout)
+}
+
+// WaitForVersions waits up to the timeout until the cluster runs the given
+// versions and is RUNNING and Ready. An empty version is not upgraded, the
+// upgrade status of that component is not looked at.
+func WaitForVersions(name, kubernetesVersion, talosVersion string, timeout time.Duration) error {
+	log.Debug("Waiting for the upgrade of ", name)
+	targets := []string{}
+	if kubernetesVersion != "" {
+		targets = append(targets, "kubernetes "+kubernetesVersion)
+	}
+	if talosVersion != "" {
+		targets = append(targets, "talos "+talosVersion)
+	}
+	deadline := utils.Now().Add(timeout)
+	for {
+		if !utils.Now().Before(deadline) {
+			return fmt.Errorf("timeout waiting for %s to run %s", name, strings.Join(targets, " and "))
+		}
+		upgraded, err := componentUpgraded(name, "Kubernetes", kubernetesVersion, utils.GetKubernetesUpgradeStatus)
+		if err != nil {
+			utils.Sleep(10 * time.Second)
+			continue
+		}
+		if upgraded {
+			upgraded, err = componentUpgraded(name, "Talos", talosVersion, utils.GetTalosUpgradeStatus)
+			if err != nil {
+				utils.Sleep(10 * time.Second)
+				continue
+			}
+		}
+		status, err := utils.GetClusterStatus(name)
+		if err != nil {
+			utils.Sleep(10 * time.Second)
+			continue
+		}
+		log.Debugf("Upgrade status: upgraded=%t, cluster %s ready=%t", upgraded, status.Spec.Phase, status.Spec.Ready)
+		if upgraded && status.Spec.Phase == "RUNNING" && status.Spec.Ready {
+			return nil
+		}
+		utils.Sleep(10 * time.Second)
+	}
+}
+
+// componentUpgraded reports whether the last upgrade of the component is to
+// the version and none is in progress. A component without a version is not
+// upgraded and counts as done.
+func componentUpgraded(name, component, version string, get func(string) (utils.UpgradeStatus, error)) (bool, error) {
+	if version == "" {
+		return true, nil
+	}
+	status, err := get(name)
+	if err != nil {
+		return false, err
+	}
+	log.Debugf("%s upgrade status: %q, last %s, current %s", component, status.Spec.Status, status.Spec.LastUpgradeVersion, status.Spec.CurrentUpgradeVersion)
+	if status.Spec.Error != "" {
+		log.Warnf("%s upgrade of %s: %s", component, name, status.Spec.Error)
+	}
+	return sameVersion(status.Spec.LastUpgradeVersion, version) && status.Spec.CurrentUpgradeVersion == "", nil
+}
@@ -0,0 +1,108 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+func TestValidateVersionJump(t *testing.T) {
+	tests := []struct {
+		from, to string
+		ok       bool
+	}{
+		{"v1.29.4", "v1.29.5", true},
+		{"v1.29.4", "v1.30.0", true},
+		{"1.29.4", "v1.30.1", true},
+		{"v1.29.4", "v1.29.4", true},
+		{"v1.29.4", "v1.31.0", false},
+		{"v1.29.4", "v1.28.9", false},
+		{"v1.29.4", "v1.29.3", false},
+		{"v1.29.4", "v2.0.0", false},
+		{"v1.29.4", "v1.30", false},
+		{"v1.29.4", "latest", false},
+	}
+	for _, tt := range tests {
+		err := ValidateVersionJump("kubernetes", tt.from, tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateVersionJump(%s, %s) = %v, want ok=%t", tt.from, tt.to, err, tt.ok)
+		}
+	}
+}
+
+// fake installs a runner with the responses and a fake clock for the test
+func fake(t *testing.T, responses map[string][]utilstest.Response) (*utilstest.Runner, *utilstest.Clock) {
+	t.Helper()
+	runner := utilstest.NewRunner(t, responses)
+	clock := utilstest.NewClock()
+	t.Cleanup(utils.SetRunner(runner))
+	t.Cleanup(utils.SetClock(clock))
+	return runner, clock
+}
+
+// upgradeStatus is a recorded Kubernetes or Talos upgrade status
+func upgradeStatus(last, current string) utilstest.Response {
+	return utilstest.Response{Stdout: fmt.Sprintf(`{"spec":{"status":"","lastupgradeversion":%q,"currentupgradeversion":%q}}`, last, current)}
+}
+
+// clusterStatus is a recorded cluster status
+func clusterStatus(phase string, ready bool) utilstest.Response {
+	return utilstest.Response{Stdout: fmt.Sprintf(`{"metadata":{"id":"dev-1a2b"},"spec":{"phase":%q,"ready":%t}}`, phase, ready)}
+}
+
+const (
+	kubernetesStatus = "omnictl get kubernetesupgradestatus dev-1a2b -o json"
+	talosStatus      = "omnictl get talosupgradestatus dev-1a2b -o json"
+	statusOfCluster  = "omnictl get clusterstatus dev-1a2b -o json"
+)
+
+func TestWaitForVersions(t *testing.T) {
+	t.Run("upgraded after the rollout", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{
+			kubernetesStatus: {upgradeStatus("1.29.4", "1.30.1"), {Err: errors.New("exit status 1")}, upgradeStatus("1.30.1", "")},
+			talosStatus:      {upgradeStatus("1.7.4", "")},
+			statusOfCluster:  {clusterStatus("RUNNING", true), clusterStatus("SCALING_UP", false), clusterStatus("RUNNING", true)},
+		})
+		if err := WaitForVersions("dev-1a2b", "v1.30.1", "v1.7.4", 30*time.Minute); err != nil {
+			t.Fatalf("WaitForVersions() error = %v", err)
+		}
+		// upgrading, a failed status and a cluster that is not ready yet
+		if clock.Slept != 30*time.Second {
+			t.Errorf("slept %v, want 30s", clock.Slept)
+		}
+	})
+
+	t.Run("kubernetes only", func(t *testing.T) {
+		// the runner fails the test when the Talos upgrade status is looked at
+		_, clock := fake(t, map[string][]utilstest.Response{
+			kubernetesStatus: {upgradeStatus("1.29.4", "1.30.1"), upgradeStatus("1.30.1", "")},
+			statusOfCluster:  {clusterStatus("RUNNING", true)},
+		})
+		if err := WaitForVersions("dev-1a2b", "v1.30.1", "", 30*time.Minute); err != nil {
+			t.Fatalf("WaitForVersions() error = %v", err)
+		}
+		if clock.Slept != 10*time.Second {
+			t.Errorf("slept %v, want 10s", clock.Slept)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{
+			kubernetesStatus: {upgradeStatus("1.29.4", "1.30.1")},
+			talosStatus:      {upgradeStatus("1.7.4", "")},
+			statusOfCluster:  {clusterStatus("RUNNING", true)},
+		})
+		err := WaitForVersions("dev-1a2b", "v1.30.1", "v1.7.4", 5*time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "timeout waiting for dev-1a2b to run kubernetes v1.30.1 and talos v1.7.4") {
+			t.Fatalf("WaitForVersions() error = %v, want a timeout", err)
+		}
+		if clock.Slept != 5*time.Minute {
+			t.Errorf("slept %v, want the timeout of 5m", clock.Slept)
+		}
+	})
+}
@@ -0,0 +1,406 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// NodeGroupClaim is the struct for the NodeGroupClaim custom resource
+type NodeGroupClaim struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		Labels            map[string]string `json:"labels,omitempty"`
+		CreationTimestamp time.Time         `json:"creationTimestamp,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		CompositionSelector struct {
+			MatchLabels map[string]string `json:"matchLabels"`
+		} `json:"compositionSelector"`
+		ID         string              `json:"id"`
+		Parameters NodeGroupParameters `json:"parameters"`
+	} `json:"spec"`
+}
+
+// NodeGroupParameters are the parameters of a NodeGroupClaim
+type NodeGroupParameters struct {
+	Replicas            int    `json:"replicas"`
+	Size                int    `json:"size"`
+	Image               string `json:"image"`
+	ImageType           string `json:"imageType"`
+	MachineType         string `json:"machineType"`
+	ServiceAccountEmail string `json:"serviceAccountEmail,omitempty"`
+	Zone                string `json:"zone"`
+	Region              string `json:"region,omitempty"`
+	ResourceGroup       string `json:"resourceGroup,omitempty"`
+	SubnetID            string `json:"subnetId,omitempty"`
+	SSHPublicKey        string `json:"sshPublicKey,omitempty"`
+}
+
+// ParseClaims parses a multi-document YAML stream of NodeGroupClaims
+func ParseClaims(r io.Reader) ([]NodeGroupClaim, error) {
+	claims := []NodeGroupClaim{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(r))
+	for {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return claims, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+		claim := NodeGroupClaim{}
+		if err := sigsyaml.Unmarshal(doc, &claim); err != nil {
+			return nil, err
+		}
+		if claim.Metadata.Name == "" {
+			continue
+		}
+		claims = append(claims, claim)
+	}
+}
+
+// ListClaims lists the NodeGroupClaims in the ops cluster
+func ListClaims() ([]NodeGroupClaim, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	output, stderr, err := runner.Run(ctx, nil, "kubectl", "get", "nodegroupclaims", "-o", "json")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
+	}
+
+	if err != nil {
+		log.Errorf("Error getting nodegroupclaims: %v, stderr: %s", err, stderr)
+		return nil, err
+	}
+
+	list := struct {
+		Items []NodeGroupClaim `json:"items"`
+	}{}
+	if err := json.Unmarshal(output, &list); err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+const (
+	// EnvironmentLabel is the label of a NodeGroupClaim that holds its environment
+	EnvironmentLabel = "tanuu.dev/environment"
+	// RoleLabel is the label of a NodeGroupClaim that holds the role of its machines
+	RoleLabel = "tanuu.dev/role"
+	// ProtectedLabel set to "true" on a claim or Omni cluster blocks deleting the environment without --force
+	ProtectedLabel = "protected"
+)
+
+// Environment returns the environment the claim belongs to, from its label.
+// Claims created before the label was added fall back to their name.
+func (c NodeGroupClaim) Environment() string {
+	if name := c.Metadata.Labels[EnvironmentLabel]; name != "" {
+		return name
+	}
+	return EnvironmentName(c.Metadata.Name)
+}
+
+// Role returns the role of the machines of the claim, from its label. Claims
+// created before the label was added fall back to the role in their name after
+// the environment, and are workers when it names none.
+func (c NodeGroupClaim) Role() string {
+	if role := c.Metadata.Labels[RoleLabel]; role != "" {
+		return role
+	}
+	id := strings.TrimPrefix(c.Metadata.Name, c.Environment())
+	switch {
+	case strings.Contains(id, "gpu"):
+		return "gpu"
+	case strings.Contains(id, "ctlr"):
+		return "ctlr"
+	default:
+		return "worker"
+	}
+}
+
+// ClaimGroups returns the node groups of the claims
+func ClaimGroups(claims []NodeGroupClaim) []GroupInstances {
+	groups := []GroupInstances{}
+	for _, claim := range claims {
+		groups = append(groups, GroupInstances{Claim: claim.Metadata.Name, Role: claim.Role(), Replicas: claim.Spec.Parameters.Replicas})
+	}
+	return groups
+}
+
+// Protected reports whether the claim has the protected label
+func (c NodeGroupClaim) Protected() bool {
+	return c.Metadata.Labels[ProtectedLabel] == "true"
+}
+
+// OwnedBy reports whether the claim belongs to the environment. Both the name
+// prefix and the environment must match, so dev-1a2b does not own the claims of dev-1a2b9.
+func (c NodeGroupClaim) OwnedBy(name string) bool {
+	return strings.HasPrefix(c.Metadata.Name, name+"-") && c.Environment() == name
+}
+
+// EnvironmentClaims returns the NodeGroupClaims of an environment
+func EnvironmentClaims(name string) ([]NodeGroupClaim, error) {
+	claims, err := ListClaims()
+	if err != nil {
+		return nil, err
+	}
+	owned := []NodeGroupClaim{}
+	for _, claim := range claims {
+		if claim.OwnedBy(name) {
+			owned = append(owned, claim)
+		}
+	}
+	if len(owned) == 0 {
+		return nil, fmt.Errorf("environment %s has no node groups", name)
+	}
+	return owned, nil
+}
+
+// EnvironmentName returns the environment a claim belongs to.
+// Claims are named <environment>-<role>-group.
+func EnvironmentName(claimname string) string {
+	name := strings.TrimSuffix(claimname, "-group")
+	if i := strings.LastIndex(name, "-"); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// GetResource gets a resource from the ops cluster
+func GetResource(kind, name string, resource interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	output, stderr, err := runner.Run(ctx, nil, "kubectl", "get", kind, name, "-o", "json")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Debugf("Error getting %s %s: %v, stderr: %s", kind, name, err, stderr)
+		return fmt.Errorf("kubectl get %s %s: %v: %s", kind, name, err, bytes.TrimSpace(stderr))
+	}
+	return json.Unmarshal(output, resource)
+}
+
+// ApplyManifest applies a manifest to the ops cluster
+func ApplyManifest(manifest []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	_, stderr, err := runner.Run(ctx, manifest, "kubectl", "apply", "-f", "-")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Errorf("Error applying manifest: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("kubectl apply: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// DiffManifest returns the changes applying a manifest would make to the ops cluster.
+// It returns an empty diff when the cluster is up to date.
+func DiffManifest(manifest []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	stdout, stderr, err := runner.Run(ctx, manifest, "kubectl", "diff", "-f", "-")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return "", err
+	}
+
+	// kubectl diff exits with 1 when there are differences
+	if exitCode(err) == 1 {
+		return string(stdout), nil
+	}
+	if err != nil {
+		log.Errorf("Error diffing manifest: %v, stderr: %s", err, stderr)
+		return "", fmt.Errorf("kubectl diff: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+	return string(stdout), nil
+}
+
+// WaitForCondition waits until a resource in the ops cluster has the condition
+func WaitForCondition(resource, condition string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout+time.Minute)
+	defer cancel()
+
+	_, stderr, err := runner.Run(ctx, nil, "kubectl", "wait", "--for=condition="+condition, resource, "--timeout="+timeout.String())
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Errorf("Error waiting for %s: %v, stderr: %s", resource, err, stderr)
+		return fmt.Errorf("waiting for %s to be %s: %v: %s", resource, condition, err, bytes.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// DeleteClaim deletes a single NodeGroupClaim
+func DeleteClaim(name string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	_, stderr, err := runner.Run(ctx, nil, "kubectl", "delete", "nodegroupclaim", name)
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Errorf("Error deleting nodegroupclaim: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("kubectl delete nodegroupclaim %s: %v: %s", name, err, bytes.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// ListManaged lists all Crossplane managed resources of the ops cluster
+func ListManaged() ([]ManagedResource, error) {
+	return listManaged("")
+}
+
+// listManaged lists the Crossplane managed resources matching the label selector
+func listManaged(selector string) ([]ManagedResource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	args := []string{"get", "managed", "-o", "json"}
+	if selector != "" {
+		args = append(args, "-l", selector)
+	}
+	output, stderr, err := runner.Run(ctx, nil, "kubectl", args...)
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
+	}
+
+	if err != nil {
+		log.Errorf("Error getting managed resources: %v, stderr: %s", err, stderr)
+		return nil, fmt.Errorf("kubectl get managed: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+
+	list := struct {
+		Items []ManagedResource `json:"items"`
+	}{}
+	if err := json.Unmarshal(output, &list); err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ListComposites lists the names of the NodeGroup composites
+func ListComposites() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	output, stderr, err := runner.Run(ctx, nil, "kubectl", "get", "nodegroups", "-o", "json")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
+	}
+
+	if err != nil {
+		log.Errorf("Error getting nodegroups: %v, stderr: %s", err, stderr)
+		return nil, fmt.Errorf("kubectl get nodegroups: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+
+	list := struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}{}
+	if err := json.Unmarshal(output, &list); err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, err
+	}
+	names := []string{}
+	for _, item := range list.Items {
+		names = append(names, item.Metadata.Name)
+	}
+	return names, nil
+}
+
+// DeleteResource deletes a resource of the ops cluster by its kind.group/name reference
+func DeleteResource(ref string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	_, stderr, err := runner.Run(ctx, nil, "kubectl", "delete", ref)
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Errorf("Error deleting %s: %v, stderr: %s", ref, err, stderr)
+		return fmt.Errorf("kubectl delete %s: %v: %s", ref, err, bytes.TrimSpace(stderr))
+	}
+	return nil
+}
+
+// WaitForClaimsDeleted waits until the NodeGroupClaims and the managed
+// resources composed from them are deleted, and returns the ones that remain
+// after the timeout
+func WaitForClaimsDeleted(claims []string, timeout time.Duration) ([]string, error) {
+	if len(claims) == 0 {
+		return []string{}, nil
+	}
+	selector := claimLabel + " in (" + strings.Join(claims, ",") + ")"
+	return waitGone("nodegroupclaims and their managed resources", timeout, func() ([]string, error) {
+		existing, err := ListClaims()
+		if err != nil {
+			return nil, err
+		}
+		remaining := []string{}
+		for _, claim := range existing {
+			if slices.Contains(claims, claim.Metadata.Name) {
+				remaining = append(remaining, "nodegroupclaim/"+claim.Metadata.Name)
+			}
+		}
+		resources, err := listManaged(selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, resource := range resources {
+			remaining = append(remaining, resource.Ref())
+		}
+		return remaining, nil
+	})
+}
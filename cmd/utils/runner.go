@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runner runs the external commands, kubectl and omnictl
+type Runner interface {
+	// Run runs a command with stdin, which may be nil, and returns its output.
+	// When ctx expires before the command finishes the error is ctx.Err().
+	Run(ctx context.Context, stdin []byte, name string, args ...string) (stdout, stderr []byte, err error)
+}
+
+// Clock tells the time and sleeps between polls
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+var (
+	runner Runner = execRunner{}
+	clock  Clock  = realClock{}
+)
+
+// SetRunner replaces the command runner and returns a function that restores the previous one.
+// Both clear the cached machine listings, which came from the other runner.
+func SetRunner(r Runner) (restore func()) {
+	previous := runner
+	runner = r
+	invalidateMachines()
+	return func() {
+		runner = previous
+		invalidateMachines()
+	}
+}
+
+// SetClock replaces the clock and returns a function that restores the previous one
+func SetClock(c Clock) (restore func()) {
+	previous := clock
+	clock = c
+	return func() { clock = previous }
+}
+
+// RunCommand runs a command with the runner and returns its stdout. Errors
+// include the stderr of the command.
+func RunCommand(timeout time.Duration, name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	stdout, stderr, err := runner.Run(ctx, nil, name, args...)
+	if timedOut(err) {
+		return nil, fmt.Errorf("%s timed out", name)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(string(stderr)); message != "" {
+			return nil, fmt.Errorf("%v: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout, nil
+}
+
+// Now returns the time of the clock, so waits outside this package can be tested
+func Now() time.Time { return clock.Now() }
+
+// Sleep sleeps on the clock
+func Sleep(d time.Duration) { clock.Sleep(d) }
+
+// execRunner runs commands with os/exec
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, stdin []byte, name string, args ...string) ([]byte, []byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return stdout.Bytes(), stderr.Bytes(), ctx.Err()
+	}
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// realClock is the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// exitCode returns the exit code of a failed command, or -1 when it did not exit
+func exitCode(err error) int {
+	var exitErr interface{ ExitCode() int }
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// timedOut reports whether a command was stopped by its timeout
+func timedOut(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
@@ -5,15 +5,24 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
-	"os/exec"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/json"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/logging"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/secrets"
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/state"
 )
 
 var (
@@ -26,13 +35,52 @@ var (
 // Environment struct to hold the environment variables
 type Environment struct {
 	Name                  string
+	Endpoint              string
 	ControlPlane          string
 	Workers               string
 	Gpus                  string
 	TailScaleClientID     string
 	TailScaleClientSecret string
 	GitHubToken           string
+	KubernetesVersion     string
+	TalosVersion          string
+	ExtraManifests        []string
+	Patches               []ConfigPatch
 	Gpu                   bool
+	Provider              string
+	NodeGroups            []NodeGroup
+}
+
+// Size is a provider independent machine size
+type Size string
+
+const (
+	// SizeSmall is a small general purpose machine
+	SizeSmall Size = "small"
+	// SizeMedium is a medium general purpose machine
+	SizeMedium Size = "medium"
+	// SizeLarge is a large general purpose machine
+	SizeLarge Size = "large"
+	// SizeGPU is a machine with GPUs attached
+	SizeGPU Size = "gpu"
+)
+
+// NodeGroup is a provider independent description of a group of nodes.
+// MachineType and Image override the provider's choice for Size and Role.
+type NodeGroup struct {
+	ID          string
+	Role        string
+	Replicas    int
+	Size        Size
+	DiskSize    int
+	MachineType string
+	Image       string
+}
+
+// ConfigPatch is an Omni config patch added to the cluster template
+type ConfigPatch struct {
+	ID   string
+	Data string
 }
 
 // Machines is the struct for the machines
@@ -59,50 +107,99 @@ type Machine struct {
 	} `json:"spec"`
 }
 
-// GenerateRandomString generates a random string
+// ClusterStatus is the struct for the Omni cluster status
+type ClusterStatus struct {
+	Metadata struct {
+		ID string `json:"id"`
+	} `json:"metadata"`
+	Spec struct {
+		Available bool   `json:"available"`
+		Phase     string `json:"phase"`
+		Ready     bool   `json:"ready"`
+		Machines  struct {
+			Total   int `json:"total"`
+			Healthy int `json:"healthy"`
+		} `json:"machines"`
+	} `json:"spec"`
+}
+
+// Cluster is the struct for the Omni cluster
+type Cluster struct {
+	Metadata struct {
+		ID      string            `json:"id"`
+		Labels  map[string]string `json:"labels"`
+		Created time.Time         `json:"created,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		KubernetesVersion string `json:"kubernetesversion"`
+		TalosVersion      string `json:"talosversion"`
+	} `json:"spec"`
+}
+
+// UpgradeStatus is the struct for the Omni Kubernetes and Talos upgrade status
+type UpgradeStatus struct {
+	Spec struct {
+		Status                string `json:"status"`
+		Error                 string `json:"error"`
+		Step                  string `json:"step"`
+		LastUpgradeVersion    string `json:"lastupgradeversion"`
+		CurrentUpgradeVersion string `json:"currentupgradeversion"`
+	} `json:"spec"`
+}
+
+// OmniConfigPatch is the struct for the Omni config patch resource
+type OmniConfigPatch struct {
+	Metadata struct {
+		ID     string            `json:"id"`
+		Labels map[string]string `json:"labels"`
+	} `json:"metadata"`
+	Spec struct {
+		Data string `json:"data"`
+	} `json:"spec"`
+}
+
+// Endpoint returns the Kubernetes API endpoint of the environment on the tailnet
+func Endpoint(name string) string {
+	tailnet := os.Getenv("TAILNET")
+	if tailnet == "" {
+		tailnet = "tail5abf9.ts.net"
+	}
+	return "https://" + name + "-ts." + tailnet
+}
+
+// GenerateRandomString generates a random string of length hex characters
 func GenerateRandomString(length int) (string, error) {
-	bytes := make([]byte, length/2) // because 2 hex characters represent 1 byte
+	bytes := make([]byte, (length+1)/2) // because 2 hex characters represent 1 byte
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(bytes), nil
+	return hex.EncodeToString(bytes)[:length], nil
 }
 
-// Setup sets up the logging
+// Setup reads the Omni settings and sets up the logging
 func Setup() {
-	OmniURL := os.Getenv("OMNI_ENDPOINT")
-	OmniAuth := os.Getenv("OMNI_SERVICE_ACCOUNT_KEY")
-	// print an error if the environment variable is not set
-	if OmniURL == "" {
-		log.Fatal("OMNI_URL environment variable not set")
+	OmniURL = os.Getenv("OMNI_ENDPOINT")
+	OmniAuth = os.Getenv("OMNI_SERVICE_ACCOUNT_KEY")
+	secrets.Register(OmniAuth)
+	// the log is configured from the environment, commands apply their flags on top
+	opts, err := logging.FromEnv()
+	if err == nil {
+		err = logging.Configure(opts)
 	}
-	if OmniAuth == "" {
-		log.Fatal("OMNI_AUTH environment variable not set")
-	}
-	// Get the log level from the environment variable
-	logLevelStr := os.Getenv("LOG_LEVEL")
-	if logLevelStr == "" {
-		logLevelStr = "DEFAULT"
-	}
-	logLevel, err := log.ParseLevel(logLevelStr)
 	if err != nil {
-		logLevel = log.InfoLevel
+		log.Fatal(err)
 	}
+}
 
-	// Set the log level
-	log.SetLevel(logLevel)
-
-	// Set the output destination to a file
-	logfilename := os.Getenv("LOG_NAME")
-	if logfilename == "" {
-		logfilename = "app.log"
+// CheckOmni checks that the Omni settings are set
+func CheckOmni() error {
+	if OmniURL == "" {
+		return fmt.Errorf("OMNI_ENDPOINT environment variable not set, run `doctor` to check the setup")
 	}
-	logfilename = "logs/" + logfilename
-	file, err := os.OpenFile(logfilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatal(err)
+	if OmniAuth == "" {
+		return fmt.Errorf("OMNI_SERVICE_ACCOUNT_KEY environment variable not set, run `doctor` to check the setup")
 	}
-	log.SetOutput(file)
+	return nil
 }
 
 func downloadFile(filepath string, url string) error {
@@ -137,277 +234,798 @@ func downloadFile(filepath string, url string) error {
 	return err
 }
 
-// WaitForReady waits for the managed nodes to be ready
-func WaitForReady(envname string) {
+// GroupInstances is the number of VM instances the composition of a NodeGroupClaim creates
+type GroupInstances struct {
+	// Claim is the name of the NodeGroupClaim
+	Claim string
+	// Kind is the kind of the VM resources, other composed resources are only required to be ready
+	Kind string
+	// Role is the role of the machines of the node group, e.g. ctlr or worker
+	Role     string
+	Replicas int
+}
+
+// Owns reports whether the machine is a VM instance of the node group. The
+// hostnames of the instances are the claim name with a generated suffix.
+func (g GroupInstances) Owns(machine Machine) bool {
+	return strings.HasPrefix(machine.Spec.Platformmetadata.Hostname, g.Claim+"-")
+}
+
+// MachinesByGroup groups the IDs of the machines of the node groups by the
+// role of their group. Machines of other groups are left out.
+func MachinesByGroup(groups []GroupInstances, machines []Machine) map[string][]string {
+	roles := map[string][]string{}
+	for _, machine := range machines {
+		if group, ok := groupOf(groups, machine); ok && group.Role != "" {
+			roles[group.Role] = append(roles[group.Role], machine.Metadata.ID)
+		}
+	}
+	return roles
+}
+
+// groupOf returns the node group the machine is a VM instance of
+func groupOf(groups []GroupInstances, machine Machine) (GroupInstances, bool) {
+	for _, group := range groups {
+		if group.Owns(machine) {
+			return group, true
+		}
+	}
+	return GroupInstances{}, false
+}
+
+// ManagedResource is a Crossplane managed resource
+type ManagedResource struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name              string            `json:"name"`
+		Labels            map[string]string `json:"labels"`
+		CreationTimestamp time.Time         `json:"creationTimestamp,omitempty"`
+		OwnerReferences   []struct {
+			Kind string `json:"kind"`
+			Name string `json:"name"`
+		} `json:"ownerReferences"`
+	} `json:"metadata"`
+	Status struct {
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+	} `json:"status"`
+}
+
+// ready returns the status of the Ready condition, Unknown when there is none yet
+func (r ManagedResource) ready() string {
+	for _, condition := range r.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status
+		}
+	}
+	return "Unknown"
+}
+
+// claimLabel is the label Crossplane puts on the resources composed for a claim
+const claimLabel = "crossplane.io/claim-name"
+
+// Claim returns the name of the claim the resource was composed for
+func (r ManagedResource) Claim() string {
+	return r.Metadata.Labels[claimLabel]
+}
+
+// Owner returns the name of the owner of the resource with the kind, such as
+// the composite it was composed for
+func (r ManagedResource) Owner(kind string) string {
+	for _, owner := range r.Metadata.OwnerReferences {
+		if owner.Kind == kind {
+			return owner.Name
+		}
+	}
+	return ""
+}
+
+// Ref returns the kind.group/name reference of the resource for kubectl
+func (r ManagedResource) Ref() string {
+	group, _, _ := strings.Cut(r.APIVersion, "/")
+	return strings.ToLower(r.Kind) + "." + group + "/" + r.Metadata.Name
+}
+
+// notReady describes the groups whose composed resources are not all ready
+func notReady(groups []GroupInstances, resources []ManagedResource) []string {
+	byClaim := map[string][]ManagedResource{}
+	for _, resource := range resources {
+		claim := resource.Metadata.Labels[claimLabel]
+		byClaim[claim] = append(byClaim[claim], resource)
+	}
+	pending := []string{}
+	for _, group := range groups {
+		instances, ready := 0, 0
+		others := []string{}
+		for _, resource := range byClaim[group.Claim] {
+			if resource.Kind == group.Kind {
+				instances++
+				if resource.ready() == "True" {
+					ready++
+				}
+			} else if resource.ready() != "True" {
+				others = append(others, resource.Kind+"/"+resource.Metadata.Name)
+			}
+		}
+		if instances != group.Replicas || ready != group.Replicas || len(others) > 0 {
+			status := fmt.Sprintf("%s: %d/%d %s ready", group.Claim, ready, group.Replicas, group.Kind)
+			if instances != group.Replicas {
+				status += fmt.Sprintf(", %d created", instances)
+			}
+			if len(others) > 0 {
+				status += ", not ready: " + strings.Join(others, ", ")
+			}
+			pending = append(pending, status)
+		}
+	}
+	return pending
+}
+
+// ReadyTimeout is how long the managed resources of the claims get to be Ready
+const ReadyTimeout = 5 * time.Minute
+
+// WaitForReady waits until the managed resources composed from the claims are
+// Ready and each group has the expected number of VM instances. Resources of
+// other environments are not looked at. The wait stops after the timeout or
+// when ctx is done.
+// onChange is called whenever the Ready condition of one of the VM instances changes.
+func WaitForReady(ctx context.Context, groups []GroupInstances, timeout time.Duration, onChange func(resource, status string)) error {
 	log.Debug("Waiting for the managed nodes to be ready")
+	if len(groups) == 0 {
+		return nil
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	claims := []string{}
+	kinds := map[string]string{}
+	for _, group := range groups {
+		claims = append(claims, group.Claim)
+		kinds[group.Claim] = group.Kind
+	}
+	selector := claimLabel + " in (" + strings.Join(claims, ",") + ")"
 
+	deadline := clock.Now().Add(timeout)
+	conditions := map[string]string{}
+	pending := []string{"no managed resources found"}
 	for {
-		select {
-		case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("waiting for the managed nodes to be ready: %w", err)
+		}
+		if !clock.Now().Before(deadline) {
 			log.Error("Timeout waiting for the managed nodes to be ready")
-			return
-		default:
-			// cmd := exec.Command("kubectl", "get", "managed", "-o", "jsonpath='{$.items[*].status.conditions[?(@.type==\"Ready\")].status}'")
-			cmd := exec.Command("kubectl", "get", "managed", "-o", "jsonpath='{range .items[*]}{.metadata.name}{\": \"}{.status.conditions[?(@.type==\"Ready\")].status}{\"\\n\"}{end}'")
-			output, err := cmd.Output()
-			log.Debug(cmd.String())
-			log.Debug("Output: ", string(output))
-			if err != nil {
-				log.Error("Error executing kubectl command: ", err)
-				time.Sleep(5 * time.Second)
+			return fmt.Errorf("timeout waiting for the managed nodes to be ready:\n  %s", strings.Join(pending, "\n  "))
+		}
+		call, cancel := context.WithTimeout(ctx, 5*time.Minute) // Set your desired timeout
+		output, stderr, err := runner.Run(call, nil, "kubectl", "get", "managed", "-l", selector, "-o", "json")
+		cancel()
+		if err != nil {
+			log.Errorf("Error executing kubectl command: %v, stderr: %s", err, stderr)
+			clock.Sleep(5 * time.Second)
+			continue
+		}
+		list := struct {
+			Items []ManagedResource `json:"items"`
+		}{}
+		if err := json.Unmarshal(output, &list); err != nil {
+			log.Error("Error unmarshalling JSON: ", err)
+			clock.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, resource := range list.Items {
+			if resource.Kind != kinds[resource.Metadata.Labels[claimLabel]] {
 				continue
 			}
-
-			if strings.Contains(string(output), envname) {
-				if !strings.Contains(string(output), "False") {
-					return
-				}
+			name, status := resource.Metadata.Name, resource.ready()
+			if conditions[name] != status && onChange != nil {
+				onChange(name, status)
 			}
-			log.Debug("Nodes not ready")
-			time.Sleep(5 * time.Second)
+			conditions[name] = status
 		}
+
+		pending = notReady(groups, list.Items)
+		if len(pending) == 0 {
+			return nil
+		}
+		log.Debug("Nodes not ready: ", strings.Join(pending, "; "))
+		clock.Sleep(5 * time.Second)
 	}
 }
 
-// WaitForCluster waits for the managed cluster to be ready
-func WaitForCluster(environment Environment) {
-	log.Debug("Waiting for the managed cluster to be ready")
+// ClusterTimeout is how long the managed cluster gets to be ready
+const ClusterTimeout = 5 * time.Minute
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+// WaitForCluster waits for the managed cluster to be ready. The wait stops
+// when ctx is done.
+func WaitForCluster(ctx context.Context, environment Environment) error {
+	log.Debug("Waiting for the managed cluster to be ready")
 
+	deadline := clock.Now().Add(ClusterTimeout)
 	for {
-		select {
-		case <-ctx.Done():
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("waiting for cluster %s to be ready: %w", environment.Name, err)
+		}
+		if !clock.Now().Before(deadline) {
 			log.Error("Timeout waiting for the managed cluster to be ready")
-			return
-		default:
-			cmd := exec.Command("omnictl", "cluster", "status", environment.Name)
-			log.Debug("Command: ", cmd)
-			output, err := cmd.Output()
-			if err != nil {
-				log.Error("Error executing command: ", err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			lines := strings.Split(string(output), "\n")
+			return fmt.Errorf("timeout waiting for cluster %s to be ready", environment.Name)
+		}
+		call, cancel := context.WithTimeout(ctx, 5*time.Minute) // Set your desired timeout
+		output, _, err := runner.Run(call, nil, "omnictl", "cluster", "status", environment.Name)
+		cancel()
+		if err != nil {
+			log.Error("Error executing command: ", err)
+			clock.Sleep(5 * time.Second)
+			continue
+		}
 
-			for _, line := range lines {
-				log.Debug("Cluster Status: ", line)
-				if strings.Contains(line, "Cluster") && strings.Contains(line, "RUNNING") && !strings.Contains(line, "Not") {
-					// This line starts with "Cluster" and contains both "RUNNING" and "Ready"
-					return
-				}
+		for _, line := range strings.Split(string(output), "\n") {
+			log.Debug("Cluster Status: ", line)
+			if strings.Contains(line, "Cluster") && strings.Contains(line, "RUNNING") && !strings.Contains(line, "Not") {
+				// This line starts with "Cluster" and contains both "RUNNING" and "Ready"
+				return nil
 			}
+		}
+
+		clock.Sleep(5 * time.Second)
+	}
+}
 
-			time.Sleep(5 * time.Second)
+// SyncTimeout is how long Omni gets to allocate the machines of a synced cluster template
+const SyncTimeout = 2 * time.Minute
+
+// WaitForClusterMachines waits until the Omni status of the cluster counts at
+// least the given number of machines, so the synced template has been taken up
+// before the cluster readiness is looked at. The wait stops when ctx is done.
+func WaitForClusterMachines(ctx context.Context, name string, machines int) error {
+	log.Debug("Waiting for the machines to be allocated to the cluster")
+
+	deadline := clock.Now().Add(SyncTimeout)
+	total := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("waiting for the machines of cluster %s: %w", name, err)
 		}
+		if !clock.Now().Before(deadline) {
+			log.Error("Timeout waiting for the machines to be allocated to the cluster")
+			return fmt.Errorf("timeout waiting for the machines of cluster %s: %d/%d allocated", name, total, machines)
+		}
+		status, err := GetClusterStatus(name)
+		if err == nil {
+			total = status.Spec.Machines.Total
+			if total >= machines {
+				return nil
+			}
+		}
+		clock.Sleep(5 * time.Second)
 	}
 }
 
-// FindReadyNodes finds the ready nodes
-func FindReadyNodes(environment string) ([]Machine, error) {
-	var machines Machines
-	nodelist := &bytes.Buffer{}
-	cmd := exec.Command("omnictl", "get", "machines", "-o", "jsonpath='{.metadata.id}'")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Stdout = nodelist
+// machineCacheTTL is how long a listing of the machine statuses is reused within a run.
+// Polls sleep at least as long between lookups, so they always see fresh statuses.
+const machineCacheTTL = 5 * time.Second
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+// cachedMachines is a listing of the machine statuses
+type cachedMachines struct {
+	fetched  time.Time
+	machines []Machine
+}
+
+var (
+	machineCacheMu sync.Mutex
+	machineCache   = map[string]cachedMachines{}
+)
+
+// invalidateMachines drops the cached machine statuses, after a change in Omni
+func invalidateMachines() {
+	machineCacheMu.Lock()
+	defer machineCacheMu.Unlock()
+	machineCache = map[string]cachedMachines{}
+}
+
+// listMachines lists the statuses of the machines in Omni matching the label
+// selector in a single call, or of all machines when the selector is empty
+func listMachines(selector string) ([]Machine, error) {
+	machineCacheMu.Lock()
+	defer machineCacheMu.Unlock()
+	if cached, ok := machineCache[selector]; ok && clock.Now().Sub(cached.fetched) < machineCacheTTL {
+		log.Debug("Using cached machine statuses")
+		return cached.machines, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	// Run the command
-	err := cmd.Run()
+	args := []string{"get", "machinestatus", "-o", "json"}
+	if selector != "" {
+		args = append(args, "-l", selector)
+	}
+	output, stderr, err := runner.Run(ctx, nil, "omnictl", args...)
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
+	}
+	if err != nil {
+		log.Errorf("Error listing machine statuses: %v, stderr: %s", err, stderr)
+		return nil, fmt.Errorf("listing machine statuses: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+
+	machines, err := decodeResources(output, func(m Machine) string { return m.Metadata.ID })
 	if err != nil {
-		log.Error("Error executing command: ", err)
+		log.Error("Error unmarshalling JSON: ", err)
 		return nil, err
 	}
+	machineCache[selector] = cachedMachines{fetched: clock.Now(), machines: machines}
+	return machines, nil
+}
 
-	log.Debug("Node list: ", nodelist.String())
-	nodestring := strings.Trim(nodelist.String(), "'\n")
-	nodeids := strings.Split(nodestring, "\n'\n'\n")
-	for _, nodeid := range nodeids {
-		// Trim leading and trailing newline and quote characters from each node ID
-		nodeid = strings.Trim(nodeid, "'\n")
-		log.Debug("Node ID: ", nodeid)
-		cmd := exec.CommandContext(ctx, "omnictl", "get", "machinestatus", nodeid, "-o", "json")
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
-		var stdout bytes.Buffer
-		cmd.Stdout = &stdout
-
-		// Run the command
-		err := cmd.Run()
-		if err != nil {
-			log.Error("Error running command: ", err)
-			return nil, err
+// FindGroupMachines finds the machines that are VM instances of the node
+// groups, by the "<claim>-" prefix the compositions give their hostnames.
+// Omni only labels a machine once it is allocated to a cluster, so the
+// machines are listed in a single call and matched here.
+func FindGroupMachines(groups []GroupInstances) ([]Machine, error) {
+	all, err := listMachines("")
+	if err != nil {
+		return nil, err
+	}
+	machines := []Machine{}
+	for _, machine := range all {
+		if _, ok := groupOf(groups, machine); ok {
+			machines = append(machines, machine)
 		}
+	}
+	log.Debug("Machines: ", machines)
+	return machines, nil
+}
 
-		node := Machine{}
-		err = json.Unmarshal(stdout.Bytes(), &node)
-		if err != nil {
-			log.Error("Error unmarshalling JSON: ", err)
-			return nil, err
+// ListMachines lists all machines registered in Omni
+func ListMachines() ([]Machine, error) {
+	return listMachines("")
+}
+
+// FindClusterMachines finds the machines that are part of the Omni cluster,
+// filtered by Omni on the cluster label
+func FindClusterMachines(cluster string) ([]Machine, error) {
+	return listMachines("omni.sidero.dev/cluster=" + cluster)
+}
+
+// MachinesTimeout is how long booting machines get to register in Omni
+const MachinesTimeout = 10 * time.Minute
+
+// groupMachines returns the machines of each node group, by the hostname the
+// composition gives its VM instances, sorted by hostname and ID
+func groupMachines(groups []GroupInstances, machines []Machine) map[string][]Machine {
+	byClaim := map[string][]Machine{}
+	for _, machine := range machines {
+		if group, ok := groupOf(groups, machine); ok {
+			byClaim[group.Claim] = append(byClaim[group.Claim], machine)
+		}
+	}
+	for _, owned := range byClaim {
+		sort.Slice(owned, func(i, j int) bool {
+			a, b := owned[i], owned[j]
+			if a.Spec.Platformmetadata.Hostname != b.Spec.Platformmetadata.Hostname {
+				return a.Spec.Platformmetadata.Hostname < b.Spec.Platformmetadata.Hostname
+			}
+			return a.Metadata.ID < b.Metadata.ID
+		})
+	}
+	return byClaim
+}
+
+// missingMachines describes the node groups that have fewer connected machines
+// than replicas, with the machines that are not connected and the number of
+// instances that did not register at all
+func missingMachines(groups []GroupInstances, machines []Machine) []string {
+	byClaim := groupMachines(groups, machines)
+	missing := []string{}
+	for _, group := range groups {
+		connected := 0
+		disconnected := []string{}
+		for _, machine := range byClaim[group.Claim] {
+			if machine.Spec.Connected {
+				connected++
+			} else {
+				disconnected = append(disconnected, machine.Spec.Platformmetadata.Hostname)
+			}
+		}
+		if connected >= group.Replicas {
+			continue
+		}
+		status := fmt.Sprintf("%s: %d/%d connected", group.Claim, connected, group.Replicas)
+		if len(disconnected) > 0 {
+			status += ", not connected: " + strings.Join(disconnected, ", ")
 		}
+		if registered := len(byClaim[group.Claim]); registered < group.Replicas {
+			status += fmt.Sprintf(", %d not registered", group.Replicas-registered)
+		}
+		missing = append(missing, status)
+	}
+	return missing
+}
 
-		// add nodes if the spec.platformmetadata.hostname contains the environment name
-		if strings.Contains(node.Spec.Platformmetadata.Hostname, environment) {
-			machines.Machines = append(machines.Machines, node)
+// selectMachines returns the connected machines of each node group, as many as
+// its replicas. Extra machines, such as those of replaced VMs, are left out in
+// the order of their hostname so every run selects the same machines.
+func selectMachines(groups []GroupInstances, machines []Machine) []Machine {
+	byClaim := groupMachines(groups, machines)
+	selected := []Machine{}
+	for _, group := range groups {
+		count := 0
+		for _, machine := range byClaim[group.Claim] {
+			if machine.Spec.Connected && count < group.Replicas {
+				selected = append(selected, machine)
+				count++
+			}
 		}
 	}
+	return selected
+}
+
+// WaitForMachines waits until Omni has at least the expected number of
+// connected machines for each of the node groups, and returns those machines.
+// Machines count when their hostname is that of a VM instance of one of the
+// groups, so machines of other environments with a similar name are ignored.
+// The wait stops when ctx is done.
+func WaitForMachines(ctx context.Context, environment string, groups []GroupInstances, timeout time.Duration) ([]Machine, error) {
+	log.Debug("Waiting for the machines to register in Omni")
 
-	log.Debug("Machines: ", machines.Machines)
-	return machines.Machines, nil
+	deadline := clock.Now().Add(timeout)
+	missing := []string{"no machines found"}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("waiting for the machines of %s to register in Omni: %w", environment, err)
+		}
+		if !clock.Now().Before(deadline) {
+			log.Error("Timeout waiting for the machines to register in Omni")
+			return nil, fmt.Errorf("timeout waiting for the machines of %s to register in Omni:\n  %s", environment, strings.Join(missing, "\n  "))
+		}
+		machines, err := FindGroupMachines(groups)
+		if err != nil {
+			clock.Sleep(10 * time.Second)
+			continue
+		}
+		missing = missingMachines(groups, machines)
+		if len(missing) == 0 {
+			return selectMachines(groups, machines), nil
+		}
+		log.Debug("Machines not registered: ", strings.Join(missing, "; "))
+		clock.Sleep(10 * time.Second)
+	}
 }
 
 // ApplyCluster applies the cluster
-func ApplyCluster(environment Environment) {
+func ApplyCluster(environment Environment) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "omnictl", "cluster", "template", "sync", "-f", environment.Name+"-cluster.yaml")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	log.Println("Applying cluster: ", cmd)
-	err := cmd.Run()
+	log.Println("Applying cluster: ", environment.Name)
+	defer invalidateMachines()
+	_, stderr, err := runner.Run(ctx, nil, "omnictl", "cluster", "template", "sync", "-f", state.Path(environment.Name, state.ClusterFile))
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Fatalf("Command timed out: %v", ctx.Err())
-		return
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
 	}
 
 	if err != nil {
-		log.Fatalf("Error creating environment: %v, stderr: %s", err, stderr.String())
+		log.Errorf("Error syncing cluster template: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("syncing cluster template: %v: %s", err, bytes.TrimSpace(stderr))
 	}
+	return nil
 }
 
-// ListClusters lists the clusters
-func ListClusters() ([]string, error) {
+// ListOmniClusters lists the Omni clusters with their labels
+func ListOmniClusters() ([]Cluster, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	clusters := &bytes.Buffer{}
-	clusterlist := []string{}
-	cmd := exec.CommandContext(ctx, "omnictl", "get", "clusters", "-o", "jsonpath='{.metadata.id}'")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Stdout = clusters
-	err := cmd.Run()
+	output, stderr, err := runner.Run(ctx, nil, "omnictl", "get", "clusters", "-o", "json")
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Fatalf("Command timed out: %v", ctx.Err())
-		return nil, ctx.Err()
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
 	}
 
 	if err != nil {
-		log.Fatalf("Error finding environment: %v, stderr: %s", err, stderr.String())
-		return nil, err
+		log.Errorf("Error finding environment: %v, stderr: %s", err, stderr)
+		return nil, fmt.Errorf("listing clusters: %v: %s", err, bytes.TrimSpace(stderr))
 	}
 
-	log.Debug("Cluster list: ", clusters.String())
-	clusterstring := strings.Trim(clusters.String(), "'\n")
-	clusterList := strings.Split(clusterstring, "\n'\n'\n")
+	clusters, err := decodeResources(output, func(c Cluster) string { return c.Metadata.ID })
+	if err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, fmt.Errorf("listing clusters: %w", err)
+	}
+	return clusters, nil
+}
 
-	for _, clusterid := range clusterList {
-		// Trim leading and trailing newline and quote characters from each node ID
-		clusterid = strings.Trim(clusterid, "'\n")
-		log.Debug("Cluster ID: ", clusterid)
-		clusterlist = append(clusterlist, clusterid)
+// ListClusters lists the clusters
+func ListClusters() ([]string, error) {
+	clusters, err := ListOmniClusters()
+	if err != nil {
+		return nil, err
+	}
+	clusterlist := []string{}
+	for _, cluster := range clusters {
+		log.Debug("Cluster ID: ", cluster.Metadata.ID)
+		clusterlist = append(clusterlist, cluster.Metadata.ID)
 	}
 	return clusterlist, nil
 }
 
 // DeleteOmniCluster deletes the cluster
-func DeleteOmniCluster(name string) {
+func DeleteOmniCluster(name string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "omnictl", "cluster", "delete", name)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+	defer invalidateMachines()
+	_, stderr, err := runner.Run(ctx, nil, "omnictl", "cluster", "delete", name)
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Errorf("Command timed out: %v", ctx.Err())
-		return
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
 	}
 
 	if err != nil {
-		log.Errorf("Error deleting environment: %v, stderr: %s", err, stderr.String())
-		return
+		log.Errorf("Error deleting environment: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("omnictl cluster delete %s: %v: %s", name, err, bytes.TrimSpace(stderr))
 	}
 
 	log.Debug("Cluster deleted: ", name)
+	return nil
+}
+
+// deletionPoll is how often the wait for deleted resources checks what remains
+const deletionPoll = 10 * time.Second
+
+// waitGone polls remaining until it returns no resources. It returns the
+// resources that remain when the timeout expires.
+func waitGone(what string, timeout time.Duration, remaining func() ([]string, error)) ([]string, error) {
+	deadline := clock.Now().Add(timeout)
+	left := []string{}
+	for {
+		current, err := remaining()
+		if err == nil {
+			left = current
+			if len(left) == 0 {
+				return left, nil
+			}
+			log.Debugf("Waiting for %s to be deleted: %s", what, strings.Join(left, ", "))
+		}
+		if !clock.Now().Before(deadline) {
+			if err != nil {
+				return left, fmt.Errorf("timeout waiting for %s to be deleted: %w", what, err)
+			}
+			return left, fmt.Errorf("timeout waiting for %s to be deleted, remaining: %s", what, strings.Join(left, ", "))
+		}
+		clock.Sleep(deletionPoll)
+	}
+}
+
+// WaitForClusterDeleted waits until the Omni cluster is gone
+func WaitForClusterDeleted(name string, timeout time.Duration) error {
+	_, err := waitGone("Omni cluster "+name, timeout, func() ([]string, error) {
+		clusters, err := ListClusters()
+		if err != nil || !slices.Contains(clusters, name) {
+			return nil, err
+		}
+		return []string{name}, nil
+	})
+	return err
+}
+
+// ListLinks lists the IDs of the machine links in Omni
+func ListLinks() ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
+
+	output, stderr, err := runner.Run(ctx, nil, "omnictl", "get", "links", "-o", "json")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
+	}
+
+	if err != nil {
+		log.Errorf("Error listing links: %v, stderr: %s", err, stderr)
+		return nil, fmt.Errorf("listing links: %v: %s", err, bytes.TrimSpace(stderr))
+	}
+
+	type link struct {
+		Metadata struct {
+			ID string `json:"id"`
+		} `json:"metadata"`
+	}
+	links, err := decodeResources(output, func(l link) string { return l.Metadata.ID })
+	if err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, fmt.Errorf("listing links: %w", err)
+	}
+	ids := []string{}
+	for _, l := range links {
+		ids = append(ids, l.Metadata.ID)
+	}
+	return ids, nil
+}
+
+// WaitForLinksDeleted waits until the machine links are removed from Omni and
+// returns the ones that remain after the timeout
+func WaitForLinksDeleted(ids []string, timeout time.Duration) ([]string, error) {
+	if len(ids) == 0 {
+		return []string{}, nil
+	}
+	return waitGone("machine links", timeout, func() ([]string, error) {
+		links, err := ListLinks()
+		if err != nil {
+			return nil, err
+		}
+		remaining := []string{}
+		for _, id := range ids {
+			if slices.Contains(links, id) {
+				remaining = append(remaining, id)
+			}
+		}
+		return remaining, nil
+	})
 }
 
 // DeleteOmniMachine deletes the machine
-func DeleteOmniMachine(name string) {
+func DeleteOmniMachine(name string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "omnictl", "delete", "link", name)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	err := cmd.Run()
+	defer invalidateMachines()
+	_, stderr, err := runner.Run(ctx, nil, "omnictl", "delete", "link", name)
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Fatalf("Command timed out: %v", ctx.Err())
-		return
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
 	}
 
 	if err != nil {
-		log.Fatalf("Error deleting machine: %v, stderr: %s", err, stderr.String())
-		return
+		log.Errorf("Error deleting machine: %v, stderr: %s", err, stderr)
+		return fmt.Errorf("deleting machine %s: %v: %s", name, err, bytes.TrimSpace(stderr))
 	}
 
 	log.Debug("Machine deleted: ", name)
+	return nil
 }
 
-// DeleteNodes Deletes for the managed nodes
-func DeleteNodes(name string) {
+// getOmniResource gets a single Omni resource as JSON
+func getOmniResource(kind, name string, resource interface{}) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
 	defer cancel()
 
-	nodes := &bytes.Buffer{}
-	log.Debug("Deleting node claims")
+	output, stderr, err := runner.Run(ctx, nil, "omnictl", "get", kind, name, "-o", "json")
+
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return err
+	}
+
+	if err != nil {
+		log.Errorf("Error getting %s %s: %v, stderr: %s", kind, name, err, stderr)
+		return err
+	}
+
+	err = json.Unmarshal(output, resource)
+	if err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+	}
+	return err
+}
+
+// decodeResources decodes the resources omnictl lists. omnictl prints one JSON
+// or YAML document per resource; a JSON array of resources is accepted as well.
+// Empty documents and documents without an ID are skipped.
+func decodeResources[T any](output []byte, id func(T) string) ([]T, error) {
+	resources := []T{}
+	add := func(resource T) {
+		if id(resource) != "" {
+			resources = append(resources, resource)
+		}
+	}
+	if trimmed := bytes.TrimSpace(output); bytes.HasPrefix(trimmed, []byte("[")) {
+		list := []T{}
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, err
+		}
+		for _, resource := range list {
+			add(resource)
+		}
+		return resources, nil
+	}
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(output), 4096)
+	for {
+		var resource T
+		err := decoder.Decode(&resource)
+		if errors.Is(err, io.EOF) {
+			return resources, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(resources)+1, err)
+		}
+		add(resource)
+	}
+}
+
+// GetClusterStatus gets the Omni status of the cluster
+func GetClusterStatus(name string) (ClusterStatus, error) {
+	status := ClusterStatus{}
+	err := getOmniResource("clusterstatus", name, &status)
+	return status, err
+}
+
+// GetCluster gets the Omni cluster
+func GetCluster(name string) (Cluster, error) {
+	cluster := Cluster{}
+	err := getOmniResource("cluster", name, &cluster)
+	return cluster, err
+}
+
+// GetKubernetesUpgradeStatus gets the status of the Kubernetes upgrade of the cluster
+func GetKubernetesUpgradeStatus(name string) (UpgradeStatus, error) {
+	status := UpgradeStatus{}
+	err := getOmniResource("kubernetesupgradestatus", name, &status)
+	return status, err
+}
+
+// GetTalosUpgradeStatus gets the status of the Talos upgrade of the cluster
+func GetTalosUpgradeStatus(name string) (UpgradeStatus, error) {
+	status := UpgradeStatus{}
+	err := getOmniResource("talosupgradestatus", name, &status)
+	return status, err
+}
+
+// GetConfigPatches gets the config patches of the cluster
+func GetConfigPatches(cluster string) ([]OmniConfigPatch, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
 
-	cmd := exec.CommandContext(ctx, "kubectl", "get", "nodegroupclaims", "-o", "NAME", "--no-headers")
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	cmd.Stdout = nodes
-	err := cmd.Run()
+	output, stderr, err := runner.Run(ctx, nil, "omnictl", "get", "configpatches", "-l", "omni.sidero.dev/cluster="+cluster, "-o", "json")
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Fatalf("Command timed out: %v", ctx.Err())
-		return
+	if timedOut(err) {
+		log.Errorf("Command timed out: %v", err)
+		return nil, err
 	}
 
 	if err != nil {
-		log.Fatalf("Error getting nodegroupclaims: %v, stderr: %s", err, stderr.String())
-		return
+		log.Errorf("Error getting config patches: %v, stderr: %s", err, stderr)
+		return nil, err
 	}
 
-	for _, node := range strings.Split(nodes.String(), "\n") {
-		if strings.Contains(node, name) {
-			log.Debug("Deleting nodegroupclaim: ", node)
-			cmd := exec.CommandContext(ctx, "kubectl", "delete", node)
-			var stderr bytes.Buffer
-			cmd.Stderr = &stderr
-			err := cmd.Run()
+	patches, err := decodeResources(output, func(p OmniConfigPatch) string { return p.Metadata.ID })
+	if err != nil {
+		log.Error("Error unmarshalling JSON: ", err)
+		return nil, err
+	}
+	return patches, nil
+}
 
-			if ctx.Err() == context.DeadlineExceeded {
-				log.Fatalf("Command timed out: %v", ctx.Err())
-				return
-			}
+// DeleteNodes deletes the NodeGroupClaims with exactly the given names and returns the deleted claims
+func DeleteNodes(claims []string) ([]string, error) {
+	deleted := []string{}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute) // Set your desired timeout
+	defer cancel()
 
-			if err != nil {
-				log.Fatalf("Error deleting nodegroupclaim: %v, stderr: %s", err, stderr.String())
-				return
-			}
+	log.Debug("Deleting node claims")
+
+	for _, claim := range claims {
+		log.Debug("Deleting nodegroupclaim: ", claim)
+		_, stderr, err := runner.Run(ctx, nil, "kubectl", "delete", "nodegroupclaim", claim)
+
+		if timedOut(err) {
+			log.Errorf("Command timed out: %v", err)
+			return deleted, err
+		}
+
+		if err != nil {
+			log.Errorf("Error deleting nodegroupclaim: %v, stderr: %s", err, stderr)
+			return deleted, fmt.Errorf("deleting nodegroupclaim %s: %v: %s", claim, err, bytes.TrimSpace(stderr))
 		}
+		deleted = append(deleted, claim)
 	}
+	return deleted, nil
 }
@@ -0,0 +1,734 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanuudev/tanuu-omni-nodes/cmd/utils/utilstest"
+)
+
+// fake installs a runner with the responses and a fake clock for the test
+func fake(t *testing.T, responses map[string][]utilstest.Response) (*utilstest.Runner, *utilstest.Clock) {
+	t.Helper()
+	runner := utilstest.NewRunner(t, responses)
+	clock := utilstest.NewClock()
+	t.Cleanup(SetRunner(runner))
+	t.Cleanup(SetClock(clock))
+	return runner, clock
+}
+
+const (
+	listMachineStatus = "omnictl get machinestatus -o json"
+	listClusters      = "omnictl get clusters -o json"
+)
+
+// machineStatus is a recorded `omnictl get machinestatus` output
+func machineStatus(id, hostname string) string {
+	return fmt.Sprintf(`{"metadata":{"id":%q},"spec":{"connected":true,"platformmetadata":{"hostname":%q,"platform":"gcp"}}}`, id, hostname)
+}
+
+func TestGenerateRandomString(t *testing.T) {
+	for _, length := range []int{1, 4, 5, 8} {
+		s, err := GenerateRandomString(length)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != length || strings.Trim(s, "0123456789abcdef") != "" {
+			t.Errorf("GenerateRandomString(%d) = %q", length, s)
+		}
+	}
+}
+
+func TestFindGroupMachines(t *testing.T) {
+	groups := []GroupInstances{{Claim: "dev-1a2b-ctlr-group"}, {Claim: "dev-1a2b-worker-group"}}
+	tests := []struct {
+		name    string
+		output  utilstest.Response
+		want    []string
+		wantErr bool
+	}{
+		{name: "zero machines", output: utilstest.Response{Stdout: ""}, want: []string{}},
+		{name: "single machine", output: utilstest.Response{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abcde")}, want: []string{"m1"}},
+		{
+			name: "machines of several environments",
+			output: utilstest.Response{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abcde") + "\n" +
+				machineStatus("m2", "other-9f8e-worker-group-fghij") + "\n" +
+				machineStatus("m3", "dev-1a2b-worker-group-3f900") + "\n"},
+			want: []string{"m1", "m3"},
+		},
+		{
+			name: "hostnames containing the environment name",
+			output: utilstest.Response{Stdout: machineStatus("m1", "olddev-1a2b-worker-group-abcde") +
+				machineStatus("m2", "dev-1a2b-worker-group2-fghij") + machineStatus("m3", "dev-1a2b-worker-group-0")},
+			want: []string{"m3"},
+		},
+		{name: "invalid output", output: utilstest.Response{Stdout: "{\"metadata\":"}, wantErr: true},
+		{name: "error exit code", output: utilstest.Response{Stderr: "connection refused", Err: utilstest.ExitError(1)}, wantErr: true},
+		{name: "timeout", output: utilstest.Response{Err: context.DeadlineExceeded}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := fake(t, map[string][]utilstest.Response{listMachineStatus: {tt.output}})
+			machines, err := FindGroupMachines(groups)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindGroupMachines() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(runner.Calls) != 1 {
+				t.Errorf("ran %d commands, want a single list call: %v", len(runner.Calls), runner.Calls)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(machineIDs(machines), tt.want) {
+				t.Errorf("FindGroupMachines() = %v, want %v", machineIDs(machines), tt.want)
+			}
+		})
+	}
+}
+
+func TestFindClusterMachines(t *testing.T) {
+	runner, _ := fake(t, map[string][]utilstest.Response{
+		listMachineStatus + " -l omni.sidero.dev/cluster=dev-1a2b": {{Stdout: machineStatus("m1", "dev-1a2b-ctlr-0")}},
+	})
+	machines, err := FindClusterMachines("dev-1a2b")
+	if err != nil || !reflect.DeepEqual(machineIDs(machines), []string{"m1"}) {
+		t.Fatalf("FindClusterMachines() = %v, %v", machineIDs(machines), err)
+	}
+	if len(runner.Calls) != 1 {
+		t.Errorf("ran %d commands, want 1", len(runner.Calls))
+	}
+}
+
+func TestMachineCache(t *testing.T) {
+	runner, clock := fake(t, map[string][]utilstest.Response{
+		listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-0")},
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-0") + machineStatus("m2", "dev-1a2b-worker-0")},
+		},
+		"omnictl delete link m1": {{}},
+	})
+	lookup := func(want int) {
+		t.Helper()
+		machines, err := FindGroupMachines([]GroupInstances{{Claim: "dev-1a2b-ctlr"}, {Claim: "dev-1a2b-worker"}})
+		if err != nil {
+			t.Fatalf("FindGroupMachines() error = %v", err)
+		}
+		if len(machines) != want {
+			t.Errorf("found %d machines, want %d", len(machines), want)
+		}
+	}
+	lookup(1)
+	lookup(1)
+	if len(runner.Calls) != 1 {
+		t.Fatalf("ran %d commands, want the second lookup to be cached", len(runner.Calls))
+	}
+	clock.Sleep(machineCacheTTL)
+	lookup(2)
+	if len(runner.Calls) != 2 {
+		t.Fatalf("ran %d commands, want a lookup after the cache expired", len(runner.Calls))
+	}
+	if err := DeleteOmniMachine("m1"); err != nil {
+		t.Fatal(err)
+	}
+	lookup(2)
+	if len(runner.Calls) != 4 {
+		t.Errorf("ran %d commands, want a lookup after deleting a machine", len(runner.Calls))
+	}
+}
+
+// machineIDs returns the IDs of the machines
+func machineIDs(machines []Machine) []string {
+	ids := []string{}
+	for _, machine := range machines {
+		ids = append(ids, machine.Metadata.ID)
+	}
+	return ids
+}
+
+func TestListClusters(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  utilstest.Response
+		want    []string
+		wantErr bool
+	}{
+		{name: "no clusters", output: utilstest.Response{Stdout: ""}, want: []string{}},
+		{name: "single cluster", output: utilstest.Response{Stdout: `{"metadata":{"id":"dev-1a2b"},"spec":{}}`}, want: []string{"dev-1a2b"}},
+		{name: "several clusters", output: utilstest.Response{Stdout: "{\"metadata\":{\"id\":\"dev-1a2b\"}}\n{\"metadata\":{\"id\":\"ci-3c4d\"}}\n"}, want: []string{"dev-1a2b", "ci-3c4d"}},
+		{name: "invalid output", output: utilstest.Response{Stdout: "'dev-1a2b'"}, wantErr: true},
+		{name: "error exit code", output: utilstest.Response{Stderr: "unauthenticated", Err: utilstest.ExitError(1)}, wantErr: true},
+		{name: "timeout", output: utilstest.Response{Err: context.DeadlineExceeded}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake(t, map[string][]utilstest.Response{listClusters: {tt.output}})
+			clusters, err := ListClusters()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ListClusters() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(clusters, tt.want) {
+				t.Errorf("ListClusters() = %v, want %v", clusters, tt.want)
+			}
+			if tt.output.Stderr != "" && !strings.Contains(err.Error(), tt.output.Stderr) {
+				t.Errorf("error %q does not include stderr %q", err, tt.output.Stderr)
+			}
+		})
+	}
+}
+
+func TestDecodeResources(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", output: "", want: []string{}},
+		{name: "whitespace", output: "\n  \n", want: []string{}},
+		{name: "single JSON document", output: `{"metadata":{"id":"a"}}`, want: []string{"a"}},
+		{name: "JSON stream", output: "{\"metadata\":{\"id\":\"a\"}}\n{\"metadata\":{\"id\":\"b\"}}{\"metadata\":{\"id\":\"c\"}}", want: []string{"a", "b", "c"}},
+		{name: "indented JSON stream", output: "{\n  \"metadata\": {\n    \"id\": \"a\"\n  }\n}\n{\n  \"metadata\": {\n    \"id\": \"b\"\n  }\n}\n", want: []string{"a", "b"}},
+		{name: "JSON array", output: `[{"metadata":{"id":"a"}},{"metadata":{"id":"b"}}]`, want: []string{"a", "b"}},
+		{name: "empty JSON array", output: "[]", want: []string{}},
+		{name: "YAML documents", output: "metadata:\n  id: a\n---\n---\nmetadata:\n  id: b\n", want: []string{"a", "b"}},
+		{name: "document without an ID", output: `{"metadata":{}}`, want: []string{}},
+		{name: "truncated", output: `{"metadata":{"id":"a"}}{"metadata":`, wantErr: true},
+		{name: "jsonpath output", output: "'\na\n'\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters, err := decodeResources([]byte(tt.output), func(c Cluster) string { return c.Metadata.ID })
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeResources() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			ids := []string{}
+			for _, cluster := range clusters {
+				ids = append(ids, cluster.Metadata.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("decodeResources() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+// disconnected is a recorded machine status of a machine that lost its connection to Omni
+func disconnected(id, hostname string) string {
+	return strings.Replace(machineStatus(id, hostname), `"connected":true`, `"connected":false`, 1)
+}
+
+func TestWaitForMachines(t *testing.T) {
+	groups := []GroupInstances{
+		{Claim: "dev-1a2b-ctlr-group", Role: "ctlr", Replicas: 1},
+		{Claim: "dev-1a2b-worker-group", Role: "worker", Replicas: 2},
+	}
+
+	t.Run("all registered", func(t *testing.T) {
+		runner, clock := fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abc")},
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abc") + disconnected("m2", "dev-1a2b-worker-group-def")},
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abc") + machineStatus("m2", "dev-1a2b-worker-group-def") + machineStatus("m3", "dev-1a2b-worker-group-ghi")},
+		}})
+		machines, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err != nil {
+			t.Fatalf("WaitForMachines() error = %v", err)
+		}
+		if !reflect.DeepEqual(machineIDs(machines), []string{"m1", "m2", "m3"}) {
+			t.Errorf("WaitForMachines() = %v", machineIDs(machines))
+		}
+		if len(runner.Calls) != 3 || clock.Slept != 20*time.Second {
+			t.Errorf("ran %d commands and slept %v, want 3 and 20s", len(runner.Calls), clock.Slept)
+		}
+	})
+
+	t.Run("timeout lists the missing machines", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-worker-group-def") + disconnected("m2", "dev-1a2b-worker-group-ghi")},
+		}})
+		_, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err == nil {
+			t.Fatal("WaitForMachines() succeeded")
+		}
+		for _, want := range []string{
+			"dev-1a2b-ctlr-group: 0/1 connected, 1 not registered",
+			"dev-1a2b-worker-group: 1/2 connected, not connected: dev-1a2b-worker-group-ghi",
+		} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q does not report %q", err, want)
+			}
+		}
+		if clock.Slept != MachinesTimeout {
+			t.Errorf("slept %v, want %v", clock.Slept, MachinesTimeout)
+		}
+	})
+
+	t.Run("machines of other environments are ignored", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abc") + machineStatus("m2", "dev-1a2b-worker-group-def") +
+				machineStatus("m9", "olddev-1a2b-worker-group-xyz") + machineStatus("m3", "dev-1a2b-worker-group-ghi") +
+				machineStatus("m8", "dev-1a2b-worker-group2-jkl")},
+		}})
+		machines, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err != nil {
+			t.Fatalf("WaitForMachines() error = %v", err)
+		}
+		if !reflect.DeepEqual(machineIDs(machines), []string{"m1", "m2", "m3"}) {
+			t.Errorf("WaitForMachines() = %v, want m1 m2 m3", machineIDs(machines))
+		}
+	})
+
+	t.Run("stops when the context is cancelled", func(t *testing.T) {
+		runner, _ := fake(t, nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := WaitForMachines(ctx, "dev-1a2b", groups, MachinesTimeout)
+		if !errors.Is(err, context.Canceled) || len(runner.Calls) != 0 {
+			t.Errorf("WaitForMachines() error = %v after %d commands, want context.Canceled", err, len(runner.Calls))
+		}
+	})
+
+	t.Run("foreign machines do not fill a role", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-abc") + machineStatus("m2", "dev-1a2b-worker-group-def") +
+				machineStatus("m9", "olddev-1a2b-worker-group-xyz")},
+		}})
+		_, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err == nil || !strings.Contains(err.Error(), "dev-1a2b-worker-group: 1/2 connected, 1 not registered") {
+			t.Errorf("WaitForMachines() error = %v, want dev-1a2b-worker-group: 1/2 connected", err)
+		}
+	})
+
+	t.Run("extra machines are left out in hostname order", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m4", "dev-1a2b-worker-group-xyz") + machineStatus("m1", "dev-1a2b-ctlr-group-abc") +
+				machineStatus("m3", "dev-1a2b-worker-group-ghi") + disconnected("m5", "dev-1a2b-worker-group-aaa") +
+				machineStatus("m2", "dev-1a2b-worker-group-def")},
+		}})
+		machines, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err != nil {
+			t.Fatalf("WaitForMachines() error = %v", err)
+		}
+		if got := machineIDs(machines); !reflect.DeepEqual(got, []string{"m1", "m2", "m3"}) {
+			t.Errorf("WaitForMachines() = %v, want m1 m2 m3", got)
+		}
+	})
+
+	t.Run("hostnames of the AWS and Azure compositions", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{listMachineStatus: {
+			{Stdout: machineStatus("m1", "dev-1a2b-ctlr-group-0") +
+				machineStatus("m3", "dev-1a2b-worker-group-3f901") + machineStatus("m2", "dev-1a2b-worker-group-3f900")},
+		}})
+		machines, err := WaitForMachines(context.Background(), "dev-1a2b", groups, MachinesTimeout)
+		if err != nil {
+			t.Fatalf("WaitForMachines() error = %v", err)
+		}
+		if got := machineIDs(machines); !reflect.DeepEqual(got, []string{"m1", "m2", "m3"}) {
+			t.Errorf("WaitForMachines() = %v, want m1 m2 m3", got)
+		}
+	})
+}
+
+// managed is a recorded managed resource composed for a claim, ready is the
+// status of its Ready condition or empty when it has none
+func managed(kind, name, claim, ready string) string {
+	conditions := "[]"
+	if ready != "" {
+		conditions = fmt.Sprintf(`[{"type":"Synced","status":"True"},{"type":"Ready","status":%q}]`, ready)
+	}
+	return fmt.Sprintf(`{"kind":%q,"metadata":{"name":%q,"labels":{"crossplane.io/claim-name":%q}},"status":{"conditions":%s}}`, kind, name, claim, conditions)
+}
+
+// managedList is a recorded `kubectl get managed -o json` output
+func managedList(items ...string) string {
+	return `{"apiVersion":"v1","kind":"List","items":[` + strings.Join(items, ",") + `]}`
+}
+
+func TestWaitForReady(t *testing.T) {
+	groups := []GroupInstances{
+		{Claim: "dev-1a2b-ctlr-group", Kind: "Instance", Replicas: 1},
+		{Claim: "dev-1a2b-worker-group", Kind: "Instance", Replicas: 2},
+	}
+	list := "kubectl get managed -l crossplane.io/claim-name in (dev-1a2b-ctlr-group,dev-1a2b-worker-group) -o json"
+
+	t.Run("ready after a poll", func(t *testing.T) {
+		runner, clock := fake(t, map[string][]utilstest.Response{list: {
+			{Stdout: managedList(
+				managed("Instance", "dev-1a2b-ctlr-group-abc", "dev-1a2b-ctlr-group", "False"),
+				managed("Instance", "dev-1a2b-worker-group-def", "dev-1a2b-worker-group", "True"),
+			)},
+			{Err: utilstest.ExitError(1)},
+			{Stdout: managedList(
+				managed("Instance", "dev-1a2b-ctlr-group-abc", "dev-1a2b-ctlr-group", "True"),
+				managed("Instance", "dev-1a2b-worker-group-def", "dev-1a2b-worker-group", "True"),
+				managed("Instance", "dev-1a2b-worker-group-ghi", "dev-1a2b-worker-group", "True"),
+			)},
+		}})
+		changes := []string{}
+		err := WaitForReady(context.Background(), groups, ReadyTimeout, func(resource, status string) {
+			changes = append(changes, resource+"="+status)
+		})
+		if err != nil {
+			t.Fatalf("WaitForReady() error = %v", err)
+		}
+		want := []string{"dev-1a2b-ctlr-group-abc=False", "dev-1a2b-worker-group-def=True", "dev-1a2b-ctlr-group-abc=True", "dev-1a2b-worker-group-ghi=True"}
+		if !reflect.DeepEqual(changes, want) {
+			t.Errorf("changes = %v, want %v", changes, want)
+		}
+		if len(runner.Calls) != 3 || clock.Slept != 10*time.Second {
+			t.Errorf("ran %d commands and slept %v, want 3 and 10s", len(runner.Calls), clock.Slept)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		groups []GroupInstances
+		output string
+		want   []string
+	}{
+		{
+			name:   "no resources yet",
+			groups: groups,
+			output: managedList(),
+			want:   []string{"dev-1a2b-ctlr-group: 0/1 Instance ready, 0 created", "dev-1a2b-worker-group: 0/2 Instance ready, 0 created"},
+		},
+		{
+			name:   "no Ready condition yet",
+			groups: groups[:1],
+			output: managedList(managed("Instance", "dev-1a2b-ctlr-group-abc", "dev-1a2b-ctlr-group", "")),
+			want:   []string{"dev-1a2b-ctlr-group: 0/1 Instance ready"},
+		},
+		{
+			name:   "fewer instances than replicas",
+			groups: groups[1:],
+			output: managedList(managed("Instance", "dev-1a2b-worker-group-def", "dev-1a2b-worker-group", "True")),
+			want:   []string{"dev-1a2b-worker-group: 1/2 Instance ready, 1 created"},
+		},
+		{
+			name:   "composed resource not ready",
+			groups: []GroupInstances{{Claim: "az-9c8d-ctlr-group", Kind: "LinuxVirtualMachine", Replicas: 1}},
+			output: managedList(
+				managed("LinuxVirtualMachine", "az-9c8d-ctlr-group-abc", "az-9c8d-ctlr-group", "True"),
+				managed("NetworkInterface", "az-9c8d-ctlr-group-nic", "az-9c8d-ctlr-group", "False"),
+			),
+			want: []string{"az-9c8d-ctlr-group: 1/1 LinuxVirtualMachine ready, not ready: NetworkInterface/az-9c8d-ctlr-group-nic"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := []string{}
+			for _, group := range tt.groups {
+				claims = append(claims, group.Claim)
+			}
+			command := "kubectl get managed -l crossplane.io/claim-name in (" + strings.Join(claims, ",") + ") -o json"
+			_, clock := fake(t, map[string][]utilstest.Response{command: {{Stdout: tt.output}}})
+			err := WaitForReady(context.Background(), tt.groups, ReadyTimeout, nil)
+			if err == nil {
+				t.Fatal("WaitForReady() succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not report %q", err, want)
+				}
+			}
+			if clock.Slept != 5*time.Minute {
+				t.Errorf("slept %v, want the timeout of 5m", clock.Slept)
+			}
+		})
+	}
+
+	t.Run("resources of other environments are ignored", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{list: {{Stdout: managedList(
+			managed("Instance", "dev-1a2b-ctlr-group-abc", "dev-1a2b-ctlr-group", "True"),
+			managed("Instance", "dev-1a2b-worker-group-def", "dev-1a2b-worker-group", "True"),
+			managed("Instance", "dev-1a2b-worker-group-ghi", "dev-1a2b-worker-group", "True"),
+			managed("Instance", "dev-1a2b9-worker-group-jkl", "dev-1a2b9-worker-group", "False"),
+		)}}})
+		if err := WaitForReady(context.Background(), groups, ReadyTimeout, nil); err != nil {
+			t.Fatalf("WaitForReady() error = %v", err)
+		}
+	})
+}
+
+func TestWaitForCluster(t *testing.T) {
+	status := "omnictl cluster status dev-1a2b"
+	t.Run("running", func(t *testing.T) {
+		runner, _ := fake(t, map[string][]utilstest.Response{status: {
+			{Stdout: "Cluster \"dev-1a2b\" SCALING_UP Not Ready\n"},
+			{Stderr: "connection reset", Err: utilstest.ExitError(1)},
+			{Stdout: "Cluster \"dev-1a2b\" RUNNING Ready (3/3) (healthy/total)\n"},
+		}})
+		if err := WaitForCluster(context.Background(), Environment{Name: "dev-1a2b"}); err != nil {
+			t.Fatalf("WaitForCluster() error = %v", err)
+		}
+		if len(runner.Calls) != 3 {
+			t.Errorf("ran %d commands, want 3", len(runner.Calls))
+		}
+	})
+	t.Run("running but not ready", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{status: {{Stdout: "Cluster \"dev-1a2b\" RUNNING Not Ready (1/3) (healthy/total)\n"}}})
+		err := WaitForCluster(context.Background(), Environment{Name: "dev-1a2b"})
+		if err == nil || !strings.Contains(err.Error(), "timeout") {
+			t.Fatalf("WaitForCluster() error = %v, want a timeout", err)
+		}
+	})
+}
+
+func TestWaitForClusterMachines(t *testing.T) {
+	status := "omnictl get clusterstatus dev-1a2b -o json"
+	allocated := func(total int) string {
+		return fmt.Sprintf(`{"metadata":{"id":"dev-1a2b"},"spec":{"phase":"SCALING_UP","machines":{"total":%d,"healthy":0}}}`, total)
+	}
+	t.Run("allocated after a poll", func(t *testing.T) {
+		runner, clock := fake(t, map[string][]utilstest.Response{status: {
+			{Stderr: "not found", Err: utilstest.ExitError(1)},
+			{Stdout: allocated(1)},
+			{Stdout: allocated(3)},
+		}})
+		if err := WaitForClusterMachines(context.Background(), "dev-1a2b", 3); err != nil {
+			t.Fatalf("WaitForClusterMachines() error = %v", err)
+		}
+		if len(runner.Calls) != 3 || clock.Slept != 10*time.Second {
+			t.Errorf("ran %d commands and slept %v, want 3 and 10s", len(runner.Calls), clock.Slept)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{status: {{Stdout: allocated(2)}}})
+		err := WaitForClusterMachines(context.Background(), "dev-1a2b", 3)
+		if err == nil || !strings.Contains(err.Error(), "2/3 allocated") {
+			t.Fatalf("WaitForClusterMachines() error = %v, want 2/3 allocated", err)
+		}
+		if clock.Slept != SyncTimeout {
+			t.Errorf("slept %v, want %v", clock.Slept, SyncTimeout)
+		}
+	})
+}
+
+func TestDeleteNodes(t *testing.T) {
+	claims := []string{"dev-1a2b-ctlr-group", "dev-1a2b-worker-group"}
+	t.Run("deletes exactly the given claims", func(t *testing.T) {
+		runner, _ := fake(t, map[string][]utilstest.Response{
+			"kubectl delete nodegroupclaim dev-1a2b-ctlr-group":   {{}},
+			"kubectl delete nodegroupclaim dev-1a2b-worker-group": {{}},
+		})
+		deleted, err := DeleteNodes(claims)
+		if err != nil {
+			t.Fatalf("DeleteNodes() error = %v", err)
+		}
+		if !reflect.DeepEqual(deleted, claims) {
+			t.Errorf("DeleteNodes() = %v, want %v", deleted, claims)
+		}
+		if len(runner.Calls) != 2 {
+			t.Errorf("ran %d commands, want 2: %v", len(runner.Calls), runner.Calls)
+		}
+	})
+	t.Run("no claims", func(t *testing.T) {
+		runner, _ := fake(t, map[string][]utilstest.Response{})
+		deleted, err := DeleteNodes(nil)
+		if err != nil || len(deleted) != 0 || len(runner.Calls) != 0 {
+			t.Fatalf("DeleteNodes() = %v, %v after %v, want nothing deleted", deleted, err, runner.Calls)
+		}
+	})
+	t.Run("delete fails", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{
+			"kubectl delete nodegroupclaim dev-1a2b-ctlr-group": {{Stderr: "forbidden", Err: utilstest.ExitError(1)}},
+		})
+		deleted, err := DeleteNodes(claims)
+		if err == nil || !strings.Contains(err.Error(), "forbidden") {
+			t.Fatalf("DeleteNodes() error = %v, want the stderr of kubectl", err)
+		}
+		if len(deleted) != 0 {
+			t.Errorf("DeleteNodes() = %v, want nothing deleted", deleted)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{"kubectl delete nodegroupclaim dev-1a2b-ctlr-group": {{Err: context.DeadlineExceeded}}})
+		if _, err := DeleteNodes(claims); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("DeleteNodes() error = %v, want a timeout", err)
+		}
+	})
+}
+
+func TestDeleteOmniCluster(t *testing.T) {
+	fake(t, map[string][]utilstest.Response{"omnictl cluster delete dev-1a2b": {{Stderr: "cluster not found", Err: utilstest.ExitError(1)}}})
+	if err := DeleteOmniCluster("dev-1a2b"); err == nil || !strings.Contains(err.Error(), "cluster not found") {
+		t.Fatalf("DeleteOmniCluster() error = %v, want the stderr of omnictl", err)
+	}
+}
+
+func TestWaitForClusterDeleted(t *testing.T) {
+	t.Run("gone after a poll", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{listClusters: {
+			{Stdout: `[{"metadata":{"id":"dev-1a2b"}}]`},
+			{Stdout: `[{"metadata":{"id":"other-9f8e"}}]`},
+		}})
+		if err := WaitForClusterDeleted("dev-1a2b", time.Minute); err != nil {
+			t.Fatalf("WaitForClusterDeleted() error = %v", err)
+		}
+		if clock.Slept != deletionPoll {
+			t.Errorf("slept %v, want one poll", clock.Slept)
+		}
+	})
+	t.Run("timeout", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{listClusters: {{Stdout: `[{"metadata":{"id":"dev-1a2b"}}]`}}})
+		err := WaitForClusterDeleted("dev-1a2b", time.Minute)
+		if err == nil || !strings.Contains(err.Error(), "remaining: dev-1a2b") {
+			t.Fatalf("WaitForClusterDeleted() error = %v, want a timeout", err)
+		}
+	})
+}
+
+func TestWaitForLinksDeleted(t *testing.T) {
+	links := "omnictl get links -o json"
+	fake(t, map[string][]utilstest.Response{links: {
+		{Stdout: `{"metadata":{"id":"m1"}}` + "\n" + `{"metadata":{"id":"m2"}}` + "\n" + `{"metadata":{"id":"m9"}}`},
+		{Stdout: `{"metadata":{"id":"m2"}}` + "\n" + `{"metadata":{"id":"m9"}}`},
+	}})
+	remaining, err := WaitForLinksDeleted([]string{"m1", "m2"}, time.Minute)
+	if err == nil || !reflect.DeepEqual(remaining, []string{"m2"}) {
+		t.Fatalf("WaitForLinksDeleted() = %v, %v, want m2 remaining", remaining, err)
+	}
+}
+
+func TestWaitForClaimsDeleted(t *testing.T) {
+	claims := "kubectl get nodegroupclaims -o json"
+	list := "kubectl get managed -o json -l crossplane.io/claim-name in (dev-1a2b-ctlr-group)"
+	instance := `{"apiVersion":"compute.gcp.upbound.io/v1beta1","kind":"Instance","metadata":{"name":"dev-1a2b-ctlr-group-x7k2p"}}`
+	t.Run("claims and instances deleted", func(t *testing.T) {
+		_, clock := fake(t, map[string][]utilstest.Response{
+			claims: {
+				{Stdout: `{"items":[{"metadata":{"name":"dev-1a2b-ctlr-group"}}]}`},
+				{Stdout: `{"items":[{"metadata":{"name":"other-9f8e-ctlr-group"}}]}`},
+			},
+			list: {{Stdout: managedList(instance)}, {Stdout: managedList(instance)}, {Stdout: managedList()}},
+		})
+		remaining, err := WaitForClaimsDeleted([]string{"dev-1a2b-ctlr-group"}, time.Minute)
+		if err != nil || len(remaining) != 0 {
+			t.Fatalf("WaitForClaimsDeleted() = %v, %v", remaining, err)
+		}
+		if clock.Slept != 2*deletionPoll {
+			t.Errorf("slept %v, want two polls", clock.Slept)
+		}
+	})
+	t.Run("instance remains", func(t *testing.T) {
+		fake(t, map[string][]utilstest.Response{
+			claims: {{Stdout: `{"items":[]}`}},
+			list:   {{Stdout: managedList(instance)}},
+		})
+		remaining, err := WaitForClaimsDeleted([]string{"dev-1a2b-ctlr-group"}, time.Minute)
+		want := []string{"instance.compute.gcp.upbound.io/dev-1a2b-ctlr-group-x7k2p"}
+		if err == nil || !reflect.DeepEqual(remaining, want) {
+			t.Fatalf("WaitForClaimsDeleted() = %v, %v, want %v", remaining, err, want)
+		}
+	})
+}
+
+func TestClaimOwnedBy(t *testing.T) {
+	claim := func(name string, labels map[string]string) NodeGroupClaim {
+		c := NodeGroupClaim{}
+		c.Metadata.Name = name
+		c.Metadata.Labels = labels
+		return c
+	}
+	tests := []struct {
+		name  string
+		claim NodeGroupClaim
+		env   string
+		want  bool
+	}{
+		{name: "labelled claim", claim: claim("dev-1a2b-ctlr-group", map[string]string{EnvironmentLabel: "dev-1a2b"}), env: "dev-1a2b", want: true},
+		{name: "claim without label", claim: claim("dev-1a2b-worker-group", nil), env: "dev-1a2b", want: true},
+		{name: "longer environment name", claim: claim("dev-1a2b9-ctlr-group", map[string]string{EnvironmentLabel: "dev-1a2b9"}), env: "dev-1a2b", want: false},
+		{name: "label of another environment", claim: claim("dev-1a2b-ctlr-group", map[string]string{EnvironmentLabel: "dev"}), env: "dev-1a2b", want: false},
+		{name: "name only contains the environment", claim: claim("big-worker-group", nil), env: "worker", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.claim.OwnedBy(tt.env); got != tt.want {
+				t.Errorf("OwnedBy(%q) = %t, want %t", tt.env, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMachinesByGroup(t *testing.T) {
+	claim := func(name string, labels map[string]string) NodeGroupClaim {
+		c := NodeGroupClaim{}
+		c.Metadata.Name = name
+		c.Metadata.Labels = labels
+		return c
+	}
+	groups := ClaimGroups([]NodeGroupClaim{
+		claim("dev-1a2b-ctlr-group", map[string]string{RoleLabel: "ctlr"}),
+		claim("dev-1a2b-highmem-group", map[string]string{RoleLabel: "worker"}),
+		// created before the role label
+		claim("dev-1a2b-gpu-group", nil),
+	})
+	machines := []Machine{}
+	for id, hostname := range map[string]string{
+		"m1": "dev-1a2b-ctlr-group-abcde",
+		"m2": "dev-1a2b-highmem-group-fghij",
+		"m3": "dev-1a2b-gpu-group-klmno",
+		"m4": "olddev-1a2b-ctlr-group-pqrst",
+		"m5": "dev-1a2b-ctlr-group2-uvwxy",
+	} {
+		machine := Machine{}
+		machine.Metadata.ID = id
+		machine.Spec.Platformmetadata.Hostname = hostname
+		machines = append(machines, machine)
+	}
+	want := map[string][]string{"ctlr": {"m1"}, "worker": {"m2"}, "gpu": {"m3"}}
+	if got := MachinesByGroup(groups, machines); !reflect.DeepEqual(got, want) {
+		t.Errorf("MachinesByGroup() = %v, want %v", got, want)
+	}
+}
+
+func TestDiffManifest(t *testing.T) {
+	diff := "kubectl diff -f -"
+	tests := []struct {
+		name    string
+		output  utilstest.Response
+		want    string
+		wantErr bool
+	}{
+		{name: "up to date", output: utilstest.Response{}, want: ""},
+		{name: "differences exit with 1", output: utilstest.Response{Stdout: "+  replicas: 2\n", Err: utilstest.ExitError(1)}, want: "+  replicas: 2\n"},
+		{name: "errors exit with 2", output: utilstest.Response{Stderr: "invalid manifest", Err: utilstest.ExitError(2)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner, _ := fake(t, map[string][]utilstest.Response{diff: {tt.output}})
+			got, err := DiffManifest([]byte("kind: ConfigMap\n"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DiffManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("DiffManifest() = %q, want %q", got, tt.want)
+			}
+			if runner.Stdin[diff] != "kind: ConfigMap\n" {
+				t.Errorf("stdin = %q, want the manifest", runner.Stdin[diff])
+			}
+		})
+	}
+}
+
+func TestGetConfigPatches(t *testing.T) {
+	get := "omnictl get configpatches -l omni.sidero.dev/cluster=dev-1a2b -o json"
+	fake(t, map[string][]utilstest.Response{get: {{Stdout: `{"metadata":{"id":"400-dev-1a2b-tailscale"},"spec":{"data":"a: 1"}}
+{"metadata":{"id":"401-dev-1a2b-extra"},"spec":{"data":"b: 2"}}
+`}}})
+	patches, err := GetConfigPatches("dev-1a2b")
+	if err != nil {
+		t.Fatalf("GetConfigPatches() error = %v", err)
+	}
+	if len(patches) != 2 || patches[1].Metadata.ID != "401-dev-1a2b-extra" || patches[1].Spec.Data != "b: 2" {
+		t.Errorf("GetConfigPatches() = %+v", patches)
+	}
+}
@@ -0,0 +1,95 @@
+// Package utilstest provides a fake command runner and clock for the tests of
+// the packages that run kubectl and omnictl through utils. Install them with
+// utils.SetRunner and utils.SetClock.
+package utilstest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Response is a recorded result of a command
+type Response struct {
+	Stdout string
+	Stderr string
+	Err    error
+}
+
+// ExitError is a command that exited with a non-zero code
+type ExitError int
+
+func (e ExitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e ExitError) ExitCode() int { return int(e) }
+
+// Runner replays recorded responses by command line. A command with several
+// responses returns them in order and then keeps returning the last one.
+// Commands without responses fail the test, unless Unknown is set.
+type Runner struct {
+	t         *testing.T
+	responses map[string][]Response
+	// Unknown is the response to the commands without responses
+	Unknown *Response
+	// Calls are the command lines run, in order
+	Calls []string
+	// Stdin is the last input given to each command line
+	Stdin map[string]string
+}
+
+// NewRunner returns a runner with the responses
+func NewRunner(t *testing.T, responses map[string][]Response) *Runner {
+	if responses == nil {
+		responses = map[string][]Response{}
+	}
+	return &Runner{t: t, responses: responses, Stdin: map[string]string{}}
+}
+
+// Outputs returns the responses of commands that always print the same output
+func Outputs(outputs map[string]string) map[string][]Response {
+	responses := map[string][]Response{}
+	for command, output := range outputs {
+		responses[command] = []Response{{Stdout: output}}
+	}
+	return responses
+}
+
+func (r *Runner) Run(_ context.Context, stdin []byte, name string, args ...string) ([]byte, []byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	r.Calls = append(r.Calls, command)
+	if stdin != nil {
+		r.Stdin[command] = string(stdin)
+	}
+	responses, ok := r.responses[command]
+	if !ok || len(responses) == 0 {
+		if r.Unknown != nil {
+			return []byte(r.Unknown.Stdout), []byte(r.Unknown.Stderr), r.Unknown.Err
+		}
+		r.t.Fatalf("unexpected command: %s", command)
+	}
+	response := responses[0]
+	if len(responses) > 1 {
+		r.responses[command] = responses[1:]
+	}
+	return []byte(response.Stdout), []byte(response.Stderr), response.Err
+}
+
+// Clock is a clock that advances when it sleeps
+type Clock struct {
+	now time.Time
+	// Slept is the time slept in total
+	Slept time.Duration
+}
+
+// NewClock returns a clock at the start of 2024
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time { return c.now }
+
+func (c *Clock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.Slept += d
+}
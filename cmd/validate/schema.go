@@ -0,0 +1,160 @@
+package validate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Schema is the subset of an OpenAPI v3 schema that the XRD and the Omni
+// cluster template use
+type Schema struct {
+	Type                  string             `json:"type"`
+	Properties            map[string]*Schema `json:"properties"`
+	Required              []string           `json:"required"`
+	Items                 *Schema            `json:"items"`
+	Enum                  []interface{}      `json:"enum"`
+	Nullable              bool               `json:"nullable"`
+	PreserveUnknownFields bool               `json:"x-kubernetes-preserve-unknown-fields"`
+}
+
+// FieldError is a validation error of a single field
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+// Errors are the field errors of a document
+type Errors struct {
+	Document string
+	Fields   []FieldError
+}
+
+func (e *Errors) Error() string {
+	lines := []string{}
+	for _, field := range e.Fields {
+		lines = append(lines, "  "+field.Error())
+	}
+	return fmt.Sprintf("invalid %s:\n%s", e.Document, strings.Join(lines, "\n"))
+}
+
+// add records a field error
+func (e *Errors) add(path, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// err returns the errors, or nil when there are none
+func (e *Errors) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Validate checks a decoded JSON or YAML value against the schema.
+// Fields that the schema does not know are reported, since the API server
+// would silently drop them.
+func (s *Schema) Validate(path string, value interface{}, errs *Errors) {
+	if s == nil {
+		return
+	}
+	if value == nil {
+		if s.Nullable {
+			return
+		}
+		errs.add(path, "must be %s, got null", s.Type)
+		return
+	}
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			errs.add(path, "must be an object, got %s", typeName(value))
+			return
+		}
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				errs.add(join(path, name), "is required")
+			}
+		}
+		names := []string{}
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := s.Properties[name]
+			if !ok {
+				if !s.PreserveUnknownFields {
+					errs.add(join(path, name), "unknown field")
+				}
+				continue
+			}
+			property.Validate(join(path, name), object[name], errs)
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			errs.add(path, "must be an array, got %s", typeName(value))
+			return
+		}
+		for i, item := range items {
+			s.Items.Validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			errs.add(path, "must be a string, got %s", typeName(value))
+		}
+	case "integer":
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			errs.add(path, "must be an integer, got %s", typeName(value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			errs.add(path, "must be a number, got %s", typeName(value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs.add(path, "must be a boolean, got %s", typeName(value))
+		}
+	}
+	if len(s.Enum) > 0 {
+		for _, allowed := range s.Enum {
+			if allowed == value {
+				return
+			}
+		}
+		errs.add(path, "must be one of %v, got %v", s.Enum, value)
+	}
+}
+
+// join appends a field name to a path
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// typeName describes the type of a decoded value
+func typeName(value interface{}) string {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return fmt.Sprintf("string %q", value)
+	case float64:
+		return fmt.Sprintf("number %v", value)
+	case bool:
+		return fmt.Sprintf("boolean %t", value)
+	}
+	return fmt.Sprintf("%T", value)
+}
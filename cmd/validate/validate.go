@@ -0,0 +1,248 @@
+package validate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+	sigsyaml "sigs.k8s.io/yaml"
+
+	"github.com/tanuudev/tanuu-omni-nodes/pkg"
+)
+
+// documents decodes a multi-document YAML stream, skipping empty documents
+func documents(data []byte) ([]map[string]interface{}, error) {
+	docs := []map[string]interface{}{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	for {
+		raw, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		doc := map[string]interface{}{}
+		if err := sigsyaml.Unmarshal(raw, &doc); err != nil {
+			return nil, fmt.Errorf("document %d: %w", len(docs)+1, err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+}
+
+// claimSpecFields are added to the spec of every claim by Crossplane
+var claimSpecFields = []string{"compositionSelector", "compositionRef", "compositionRevisionRef", "compositionRevisionSelector", "compositionUpdatePolicy", "compositeDeletePolicy", "resourceRef", "writeConnectionSecretToRef", "publishConnectionDetailsTo"}
+
+var (
+	xrdOnce   sync.Once
+	xrdSchema *Schema
+	xrdErr    error
+)
+
+// claimSchema returns the spec schema of the NodeGroupClaim from the XRD,
+// extended with the fields Crossplane adds to claims
+func claimSchema() (*Schema, error) {
+	xrdOnce.Do(func() {
+		data, err := pkg.FS.ReadFile("nodegroupdef.yaml")
+		if err != nil {
+			xrdErr = err
+			return
+		}
+		xrd := struct {
+			Spec struct {
+				Versions []struct {
+					Name   string `json:"name"`
+					Schema struct {
+						OpenAPIV3Schema *Schema `json:"openAPIV3Schema"`
+					} `json:"schema"`
+				} `json:"versions"`
+			} `json:"spec"`
+		}{}
+		if err := sigsyaml.Unmarshal(data, &xrd); err != nil {
+			xrdErr = fmt.Errorf("parsing the XRD: %w", err)
+			return
+		}
+		for _, version := range xrd.Spec.Versions {
+			if version.Name == "v1alpha1" && version.Schema.OpenAPIV3Schema != nil {
+				xrdSchema = version.Schema.OpenAPIV3Schema.Properties["spec"]
+			}
+		}
+		if xrdSchema == nil {
+			xrdErr = fmt.Errorf("the XRD has no v1alpha1 spec schema")
+			return
+		}
+		for _, field := range claimSpecFields {
+			if _, ok := xrdSchema.Properties[field]; !ok {
+				xrdSchema.Properties[field] = &Schema{Type: "object", PreserveUnknownFields: true}
+			}
+		}
+	})
+	return xrdSchema, xrdErr
+}
+
+// Claims validates rendered NodeGroupClaims against the schema of the XRD
+func Claims(manifest []byte) error {
+	spec, err := claimSchema()
+	if err != nil {
+		return err
+	}
+	docs, err := documents(manifest)
+	if err != nil {
+		return fmt.Errorf("parsing claims: %w", err)
+	}
+	for _, doc := range docs {
+		metadata, _ := doc["metadata"].(map[string]interface{})
+		name, _ := metadata["name"].(string)
+		errs := &Errors{Document: "NodeGroupClaim " + name}
+		if name == "" {
+			errs.Document = "NodeGroupClaim"
+			errs.add("metadata.name", "is required")
+		}
+		if doc["apiVersion"] != "tanuu.dev/v1alpha1" {
+			errs.add("apiVersion", "must be tanuu.dev/v1alpha1, got %v", doc["apiVersion"])
+		}
+		if doc["kind"] != "NodeGroupClaim" {
+			errs.add("kind", "must be NodeGroupClaim, got %v", doc["kind"])
+		}
+		spec.Validate("spec", doc["spec"], errs)
+		if err := errs.err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchesSchema is the schema of the config patches of a cluster template document
+var patchesSchema = &Schema{Type: "array", Items: &Schema{
+	Type: "object",
+	Properties: map[string]*Schema{
+		"name":        {Type: "string"},
+		"idOverride":  {Type: "string"},
+		"file":        {Type: "string"},
+		"inline":      {Type: "object", PreserveUnknownFields: true},
+		"labels":      {Type: "object", PreserveUnknownFields: true},
+		"annotations": {Type: "object", PreserveUnknownFields: true},
+	},
+}}
+
+// machinesSchema is the schema of the machine IDs of a machine set
+var machinesSchema = &Schema{Type: "array", Items: &Schema{Type: "string"}}
+
+// templateSchemas are the schemas of the Omni cluster template documents by kind
+var templateSchemas = map[string]*Schema{
+	"Cluster": {
+		Type:     "object",
+		Required: []string{"kind", "name", "kubernetes", "talos"},
+		Properties: map[string]*Schema{
+			"kind":             {Type: "string"},
+			"name":             {Type: "string"},
+			"labels":           {Type: "object", PreserveUnknownFields: true},
+			"kubernetes":       {Type: "object", Required: []string{"version"}, Properties: map[string]*Schema{"version": {Type: "string"}}},
+			"talos":            {Type: "object", Required: []string{"version"}, Properties: map[string]*Schema{"version": {Type: "string"}}},
+			"features":         {Type: "object", PreserveUnknownFields: true},
+			"systemExtensions": {Type: "array", Items: &Schema{Type: "string"}},
+			"descriptors":      {Type: "object", PreserveUnknownFields: true},
+			"patches":          patchesSchema,
+		},
+	},
+	"ControlPlane": {
+		Type:     "object",
+		Required: []string{"kind", "machines"},
+		Properties: map[string]*Schema{
+			"kind":             {Type: "string"},
+			"machines":         machinesSchema,
+			"machineClass":     {Type: "object", PreserveUnknownFields: true},
+			"systemExtensions": {Type: "array", Items: &Schema{Type: "string"}},
+			"patches":          patchesSchema,
+		},
+	},
+	"Workers": {
+		Type:     "object",
+		Required: []string{"kind"},
+		Properties: map[string]*Schema{
+			"kind":             {Type: "string"},
+			"name":             {Type: "string"},
+			"machines":         {Type: "array", Items: &Schema{Type: "string"}, Nullable: true},
+			"machineClass":     {Type: "object", PreserveUnknownFields: true},
+			"systemExtensions": {Type: "array", Items: &Schema{Type: "string"}},
+			"updateStrategy":   {Type: "object", PreserveUnknownFields: true},
+			"deleteStrategy":   {Type: "object", PreserveUnknownFields: true},
+			"patches":          patchesSchema,
+		},
+	},
+}
+
+// versionPattern matches the versions Omni accepts
+var versionPattern = regexp.MustCompile(`^v?\d+\.\d+\.\d+$`)
+
+// ClusterTemplate validates the structure of a rendered Omni cluster template
+func ClusterTemplate(template []byte) error {
+	docs, err := documents(template)
+	if err != nil {
+		return fmt.Errorf("parsing cluster template: %w", err)
+	}
+	errs := &Errors{Document: "cluster template"}
+	counts := map[string]int{}
+	machines := map[string]string{}
+	workers := map[string]bool{}
+	for i, doc := range docs {
+		kind, _ := doc["kind"].(string)
+		path := fmt.Sprintf("[%d]", i)
+		if kind != "" {
+			path = fmt.Sprintf("[%d](%s)", i, kind)
+		}
+		schema, ok := templateSchemas[kind]
+		if !ok {
+			errs.add(path+".kind", "must be Cluster, ControlPlane or Workers, got %v", doc["kind"])
+			continue
+		}
+		counts[kind]++
+		schema.Validate(path, doc, errs)
+		if kind == "Workers" {
+			name, _ := doc["name"].(string)
+			if name == "" {
+				name = "workers"
+			}
+			if workers[name] {
+				errs.add(path+".name", "duplicate machine set %s", name)
+			}
+			workers[name] = true
+		}
+		if kind == "Cluster" {
+			for _, component := range []string{"kubernetes", "talos"} {
+				section, _ := doc[component].(map[string]interface{})
+				if version, ok := section["version"].(string); ok && !versionPattern.MatchString(version) {
+					errs.add(path+"."+component+".version", "must look like v1.2.3, got %q", version)
+				}
+			}
+		}
+		ids, _ := doc["machines"].([]interface{})
+		for _, id := range ids {
+			id, _ := id.(string)
+			if other, ok := machines[id]; ok {
+				errs.add(path+".machines", "machine %s is also in %s", id, other)
+			}
+			machines[id] = path
+		}
+		if kind == "ControlPlane" && len(ids) == 0 {
+			errs.add(path+".machines", "needs at least one machine")
+		}
+	}
+	for _, kind := range []string{"Cluster", "ControlPlane"} {
+		if counts[kind] != 1 {
+			errs.add(kind, "must appear exactly once, found %d", counts[kind])
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,120 @@
+package validate
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+const claim = `---
+apiVersion: tanuu.dev/v1alpha1
+kind: NodeGroupClaim
+metadata:
+  name: test-1a2b-worker-group
+spec:
+  compositionSelector:
+    matchLabels:
+      provider: google
+  id: test-1a2b-worker-group
+  parameters:
+    replicas: 2
+    size: 50
+    image: projects/p/global/images/omni-worker-v5
+    imageType: pd-balanced
+    machineType: e2-highmem-4
+    zone: europe-west4-a
+`
+
+func TestClaims(t *testing.T) {
+	tests := []struct {
+		name   string
+		claim  string
+		fields []string
+	}{
+		{"valid", claim, nil},
+		{"missing image", strings.Replace(claim, "    image: projects/p/global/images/omni-worker-v5\n", "", 1), []string{"spec.parameters.image: is required"}},
+		{"empty image", strings.Replace(claim, "image: projects/p/global/images/omni-worker-v5", "image:", 1), []string{"spec.parameters.image: must be string, got null"}},
+		{"string replicas", strings.Replace(claim, "replicas: 2", `replicas: "2"`, 1), []string{`spec.parameters.replicas: must be an integer, got string "2"`}},
+		{"fractional size", strings.Replace(claim, "size: 50", "size: 50.5", 1), []string{"spec.parameters.size: must be an integer, got number 50.5"}},
+		{"typo", strings.Replace(claim, "zone:", "zoen:", 1), []string{"spec.parameters.zone: is required", "spec.parameters.zoen: unknown field"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Claims([]byte(tt.claim))
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("Claims: %v", err)
+				}
+				return
+			}
+			var errs *Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("Claims = %v, want field errors", err)
+			}
+			got := []string{}
+			for _, field := range errs.Fields {
+				got = append(got, field.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.fields, "\n") {
+				t.Errorf("field errors:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.fields, "\n"))
+			}
+			if errs.Document != "NodeGroupClaim test-1a2b-worker-group" {
+				t.Errorf("document = %q", errs.Document)
+			}
+		})
+	}
+}
+
+const cluster = `kind: Cluster
+name: test-1a2b
+kubernetes:
+  version: v1.29.4
+talos:
+  version: v1.6.7
+patches:
+  - idOverride: 100-test-1a2b
+    inline:
+      machine:
+        kubelet: {}
+---
+kind: ControlPlane
+machines:
+  - 0a1b
+---
+kind: Workers
+machines:
+  - 2c3d
+---
+kind: Workers
+name: test-1a2b
+machines:
+`
+
+func TestClusterTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     string
+	}{
+		{"valid", cluster, ""},
+		{"no control plane machines", strings.Replace(cluster, "machines:\n  - 0a1b\n", "machines:\n", 1), "[1](ControlPlane).machines: must be array, got null"},
+		{"bad version", strings.Replace(cluster, "version: v1.29.4", "version: latest", 1), `[0](Cluster).kubernetes.version: must look like v1.2.3, got "latest"`},
+		{"machine twice", strings.Replace(cluster, "  - 2c3d", "  - 0a1b", 1), "[2](Workers).machines: machine 0a1b is also in [1](ControlPlane)"},
+		{"unknown kind", cluster + "---\nkind: Worker\n", "[4](Worker).kind: must be Cluster, ControlPlane or Workers, got Worker"},
+		{"patch typo", strings.Replace(cluster, "idOverride", "idOveride", 1), "[0](Cluster).patches[0].idOveride: unknown field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ClusterTemplate([]byte(tt.template))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("ClusterTemplate: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ClusterTemplate = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -17,6 +17,9 @@ func main() {
 	if len(os.Args) > 1 {
 		cmd.Execute()
 	} else {
+		if err := utils.CheckOmni(); err != nil {
+			log.Fatal(err)
+		}
 		menu.Menu()
 	}
 
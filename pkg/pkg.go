@@ -0,0 +1,11 @@
+// Package pkg is the Crossplane Configuration package of the node groups.
+// The manifests are embedded so the bootstrap command installs the XRD and
+// compositions the code was written against.
+package pkg
+
+import "embed"
+
+// FS holds the package metadata, the XRD and the compositions
+//
+//go:embed crossplane.yaml nodegroupdef.yaml nodegroupcomp.yaml nodegroupcomp-aws.yaml nodegroupcomp-azure.yaml
+var FS embed.FS